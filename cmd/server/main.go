@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -8,6 +9,7 @@ import (
 	"opskrifter-backend/internal/api"
 	"opskrifter-backend/pkg/myDB"
 	"os"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -15,16 +17,28 @@ import (
 func main() {
 	port := os.Getenv("PORT")
 	env := flag.String("env", "dev", "Application environment: dev or prod")
+	timeout := flag.Duration("timeout", api.OperationTimeout, "How long a request may spend on the database")
+	retention := flag.Duration("retention", api.TrashRetention, "How long deleted recipes are kept before they are purged")
 	flag.Parse()
 	fmt.Printf("Running in %s mode\n", *env)
 
-	err := myDB.Init(false)
+	if err := api.CheckTags(api.Models...); err != nil {
+		log.Fatalf("error in model tags: %v", err)
+	}
+
+	myDB.StrictSchema = *env == "prod"
+	store, err := myDB.NewSQLiteStore()
 	if err != nil {
 		log.Fatalf("error init DB %v", err)
 	}
+	defer store.Close()
+
+	go api.PurgeTrash(context.Background(), store, *retention, time.Hour)
+
 	r := chi.NewRouter()
 
-	api.RegisterRoutes(r, *env)
+	api.OperationTimeout = *timeout
+	api.RegisterRoutes(r, store, *env)
 
 	log.Printf("API server running on http://localhost%s", port)
 	if err := http.ListenAndServe(port, r); err != nil {
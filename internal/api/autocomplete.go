@@ -0,0 +1,184 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// DefaultSuggestions and MaxSuggestions bound how many ingredients
+// SuggestIngredients returns.
+const (
+	DefaultSuggestions = 10
+	MaxSuggestions     = 50
+)
+
+// foldedLetters spells letters with diacritics without them. Æ, Ø and Å are
+// spelled the way they are written on keyboards without them, so "floede"
+// and "fløde" fold the same.
+var foldedLetters = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'ā': "a",
+	'ç': "c", 'č': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u",
+	'ý': "y", 'ÿ': "y",
+	'ß': "ss",
+	'æ': "ae", 'ø': "oe", 'å': "aa",
+}
+
+// foldName lowercases s and folds its diacritics.
+func foldName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if folded, ok := foldedLetters[r]; ok {
+			b.WriteString(folded)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// normalizeName folds a name and collapses its spaces, so names can be
+// compared the way users type them.
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(foldName(name)), " ")
+}
+
+// maxTypos is how many edits a query of n letters may be away from a name.
+func maxTypos(n int) int {
+	switch {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	}
+	return 2
+}
+
+// prefixDistance is the fewest insertions, deletions, substitutions and swaps
+// of neighbouring letters turning query into a prefix of name.
+func prefixDistance(query []rune, name []rune) int {
+	prev2 := make([]int, len(name)+1)
+	prev := make([]int, len(name)+1)
+	row := make([]int, len(name)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(query); i++ {
+		row[0] = i
+		for j := 1; j <= len(name); j++ {
+			cost := 1
+			if query[i-1] == name[j-1] {
+				cost = 0
+			}
+			row[j] = min(prev[j]+1, row[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && query[i-1] == name[j-2] && query[i-2] == name[j-1] {
+				row[j] = min(row[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, row = prev, row, prev2
+	}
+	return slices.Min(prev)
+}
+
+// suggestion is an ingredient with how well it matches a query: the fewest
+// edits to a prefix of its name or of one of its later words.
+type suggestion struct {
+	ingredient types.Ingredient
+	distance   int
+	laterWord  bool
+}
+
+// matchIngredient reports how well ingredient, called by any of names,
+// matches the folded query.
+func matchIngredient(query []rune, ingredient types.Ingredient, names ...string) (suggestion, bool) {
+	best := suggestion{ingredient: ingredient, distance: maxTypos(len(query)) + 1}
+
+	for _, name := range names {
+		name := []rune(foldName(name))
+		for i := range name {
+			if i > 0 && (unicode.IsLetter(name[i-1]) || unicode.IsDigit(name[i-1])) {
+				continue
+			}
+			if distance := prefixDistance(query, name[i:]); distance < best.distance ||
+				distance == best.distance && best.laterWord && i == 0 {
+				best.distance, best.laterWord = distance, i > 0
+			}
+		}
+	}
+	return best, best.distance <= maxTypos(len(query))
+}
+
+// rankIngredients returns the ingredients matching text by their name or one
+// of aliases, best first: the fewest typos, then matches at the start of a
+// name, then the shortest names, so "ost" suggests "Ost" before "Kiks til
+// ost".
+func rankIngredients(ingredients []types.Ingredient, aliases []types.IngredientAlias, text string, limit int) ([]types.Ingredient, error) {
+	query := []rune(normalizeName(text))
+	if len(query) == 0 {
+		return nil, ErrEmptySearch
+	}
+
+	names := map[string][]string{}
+	for _, alias := range aliases {
+		names[alias.IngredientID] = append(names[alias.IngredientID], alias.Name)
+	}
+
+	var matches []suggestion
+	for _, ingredient := range ingredients {
+		if s, ok := matchIngredient(query, ingredient, append([]string{ingredient.Name}, names[ingredient.ID]...)...); ok {
+			matches = append(matches, s)
+		}
+	}
+
+	slices.SortFunc(matches, func(a, b suggestion) int {
+		if a.distance != b.distance {
+			return cmp.Compare(a.distance, b.distance)
+		}
+		if a.laterWord != b.laterWord {
+			if a.laterWord {
+				return 1
+			}
+			return -1
+		}
+		if c := cmp.Compare(len(a.ingredient.Name), len(b.ingredient.Name)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ingredient.Name, b.ingredient.Name)
+	})
+
+	suggestions := make([]types.Ingredient, 0, min(limit, len(matches)))
+	for _, s := range matches[:min(limit, len(matches))] {
+		suggestions = append(suggestions, s.ingredient)
+	}
+	return suggestions, nil
+}
+
+func SuggestIngredients(q myDB.Querier, text string, limit int) ([]types.Ingredient, error) {
+	return SuggestIngredientsContext(context.Background(), q, text, limit)
+}
+
+// SuggestIngredientsContext returns at most limit ingredients for what a user
+// has typed so far, matched by their name or an alias. Case and diacritics
+// are ignored and a few typos are forgiven, so "agrk" suggests "Agurk". The
+// catalog is small enough to be ranked in memory.
+func SuggestIngredientsContext(ctx context.Context, q myDB.Querier, text string, limit int) ([]types.Ingredient, error) {
+	ingredients, err := GetAllByTypeWithOptionsContext[types.Ingredient](ctx, q, QueryOptions{})
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := GetAllByTypeWithOptionsContext[types.IngredientAlias](ctx, q, QueryOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return rankIngredients(ingredients, aliases, text, limit)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"opskrifter-backend/internal/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixDistance(t *testing.T) {
+	tests := []struct {
+		query, name string
+		distance    int
+	}{
+		{"agu", "agurk", 0},
+		{"agrk", "agurk", 1},
+		{"aguk", "agurk", 1},
+		{"augrk", "agurk", 1},
+		{"tomta", "tomat", 1},
+		{"xyz", "agurk", 3},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.distance, prefixDistance([]rune(tt.query), []rune(tt.name)), tt.query)
+	}
+	assert.Equal(t, "floede creme brulee", foldName("FLØDE Crème Brûlée"))
+}
+
+func TestSuggestIngredients(t *testing.T) {
+	store := newTestStore(t)
+
+	names := func(text string, limit int) []string {
+		suggestions, err := SuggestIngredients(store, text, limit)
+		require.NoError(t, err)
+		var out []string
+		for _, ingredient := range suggestions {
+			out = append(out, ingredient.Name)
+		}
+		return out
+	}
+
+	assert.Equal(t, "Agurk", names("agrk", 10)[0], "typos are forgiven")
+	assert.Equal(t, "Agurk", names("AGU", 10)[0])
+	assert.Equal(t, []string{"Ost", "Kiks til ost"}, names("ost", 2), "later words of a name match too, after the start")
+	assert.Contains(t, names("flode 38", 10), "Fløde 38 %")
+	assert.Contains(t, names("floede", 10), "Fløde 9 %")
+	assert.Equal(t, "Creme fraiche 18 %", names("crème f", 10)[0])
+	assert.Len(t, names("e", 3), 3)
+	assert.Empty(t, names("qqqqqq", 10))
+
+	_, err := SuggestIngredients(store, "  ", 10)
+	assert.ErrorIs(t, err, ErrEmptySearch)
+}
+
+func TestRouteSuggestIngredients(t *testing.T) {
+	_, router := newTestEnv(t)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/ingredients/?"+query, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("q=" + url.QueryEscape("agrk") + "&limit=3")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	var got []types.Ingredient
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	require.NotEmpty(t, got)
+	assert.LessOrEqual(t, len(got), 3)
+	assert.Equal(t, "Agurk", got[0].Name)
+
+	assert.Equal(t, http.StatusBadRequest, get("q=agurk&limit=0").Code)
+	assert.Equal(t, http.StatusBadRequest, get("q=agurk&limit=many").Code)
+	assert.Equal(t, http.StatusBadRequest, get("q=").Code)
+
+	resp = get("")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	assert.Greater(t, len(got), 600, "without q every ingredient is listed")
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+)
+
+// BatchMode decides what happens to the rest of a batch when one of its
+// operations fails.
+type BatchMode string
+
+const (
+	// BatchAtomic rolls the whole batch back when any operation fails.
+	BatchAtomic BatchMode = "atomic"
+	// BatchBestEffort keeps the operations that succeeded.
+	BatchBestEffort BatchMode = "best_effort"
+)
+
+// The batch operations borrow their names from JSON Patch.
+const (
+	BatchAdd     = "add"
+	BatchReplace = "replace"
+	BatchRemove  = "remove"
+)
+
+const MaxBatchSize = 1000
+
+var ErrInvalidBatch = errors.New("invalid batch")
+var ErrBatchAborted = errors.New("rolled back because another operation in the batch failed")
+var ErrBatchNotAttempted = errors.New("not attempted because an earlier operation in the batch failed")
+
+// BatchOperation adds Value, replaces the stored row with Value, or removes
+// the row with ID.
+type BatchOperation[T types.Identifiable] struct {
+	Op    string `json:"op"`
+	ID    string `json:"id,omitempty"`
+	Value T      `json:"value"`
+}
+
+// BatchItem is the outcome of the operation at the same index.
+type BatchItem struct {
+	ID  string
+	Err error
+}
+
+// BatchByType applies ops in one transaction. Failing operations are
+// reported in their BatchItem; the returned error is only set when the batch
+// could not run at all, in which case nothing was written. When an atomic
+// batch fails, the operations before the failing one are reported with
+// ErrBatchAborted and the ones after it, which never ran, with
+// ErrBatchNotAttempted.
+func BatchByType[T types.Identifiable](q myDB.Querier, mode BatchMode, ops []BatchOperation[T]) ([]BatchItem, error) {
+	return BatchByTypeContext(context.Background(), q, mode, ops)
+}
+
+func BatchByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, mode BatchMode, ops []BatchOperation[T]) ([]BatchItem, error) {
+	if mode == "" {
+		mode = BatchAtomic
+	}
+
+	if mode != BatchAtomic && mode != BatchBestEffort {
+		return nil, fmt.Errorf("%w: unknown mode %q", ErrInvalidBatch, mode)
+	}
+
+	if len(ops) > MaxBatchSize {
+		return nil, fmt.Errorf("%w: more than %d operations", ErrInvalidBatch, MaxBatchSize)
+	}
+
+	items := make([]BatchItem, len(ops))
+	failed := -1
+
+	err := myDB.WithTxContext(ctx, q, func(tx myDB.Querier) error {
+		for i, op := range ops {
+			if mode == BatchAtomic {
+				items[i].ID, items[i].Err = applyBatchOperation(ctx, tx, op)
+				if items[i].Err != nil {
+					failed = i
+					return items[i].Err
+				}
+				continue
+			}
+
+			// Each operation gets a savepoint so a failing one can be undone
+			// without losing the others.
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_item"); err != nil {
+				return err
+			}
+
+			items[i].ID, items[i].Err = applyBatchOperation(ctx, tx, op)
+			if items[i].Err != nil {
+				if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_item"); err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_item"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	if err != nil && failed < 0 {
+		return nil, err
+	}
+
+	if failed >= 0 {
+		for i := range items {
+			switch {
+			case i < failed:
+				items[i] = BatchItem{Err: ErrBatchAborted}
+			case i > failed:
+				items[i] = BatchItem{Err: ErrBatchNotAttempted}
+			}
+		}
+	}
+
+	return items, nil
+}
+
+func applyBatchOperation[T types.Identifiable](ctx context.Context, q myDB.Querier, op BatchOperation[T]) (string, error) {
+	switch op.Op {
+	case BatchAdd:
+		if withRelations, ok := any(op.Value).(types.IdentifiableWithRelations); ok {
+			return createByTypeWithRelations(ctx, q, withRelations)
+		}
+		return CreateByTypeContext(ctx, q, op.Value)
+
+	case BatchReplace:
+		if op.Value.GetID() == "" {
+			return "", ErrNoIdForType
+		}
+
+		if withRelations, ok := any(op.Value).(types.IdentifiableWithRelations); ok {
+			return withRelations.GetID(), updateByTypeWithRelations(ctx, q, withRelations)
+		}
+		return UpdateByTypeContext(ctx, q, op.Value)
+
+	case BatchRemove:
+		id := op.ID
+		if id == "" {
+			id = op.Value.GetID()
+		}
+
+		if id == "" {
+			return "", ErrNoIdForType
+		}
+		return DeleteByTypeContext[T](ctx, q, id)
+	}
+
+	return "", fmt.Errorf("%w: unknown op %q", ErrInvalidBatch, op.Op)
+}
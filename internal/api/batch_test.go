@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opskrifter-backend/internal/middleware"
+	"opskrifter-backend/internal/testutils"
+	"opskrifter-backend/internal/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchByType(t *testing.T) {
+	ops := []BatchOperation[types.Ingredient]{
+		{Op: BatchAdd, Value: types.Ingredient{Name: "Batchsalt"}},
+		{Op: BatchAdd, Value: types.Ingredient{Name: "Agurk"}},
+		{Op: BatchRemove},
+	}
+
+	t.Run("atomic", func(t *testing.T) {
+		store := newTestStore(t)
+		before, err := GetCountByType(store, types.Ingredient{})
+		require.NoError(t, err)
+
+		items, err := BatchByType(store, BatchAtomic, ops)
+		require.NoError(t, err)
+		require.Len(t, items, 3)
+		assert.ErrorIs(t, items[0].Err, ErrBatchAborted)
+		assert.Error(t, items[1].Err, "Agurk already exists")
+		assert.ErrorIs(t, items[2].Err, ErrBatchNotAttempted, "operations after the failing one never ran")
+
+		require.NoError(t, testutils.AssertCountByType(store, before, GetCountByType[types.Ingredient]))
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		store := newTestStore(t)
+		before, err := GetCountByType(store, types.Ingredient{})
+		require.NoError(t, err)
+
+		items, err := BatchByType(store, BatchBestEffort, ops)
+		require.NoError(t, err)
+		require.Len(t, items, 3)
+		require.NoError(t, items[0].Err)
+		assert.NotEmpty(t, items[0].ID)
+		assert.Error(t, items[1].Err)
+		assert.ErrorIs(t, items[2].Err, ErrNoIdForType)
+
+		require.NoError(t, testutils.AssertCountByType(store, before+1, GetCountByType[types.Ingredient]))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		store := newTestStore(t)
+		_, err := BatchByType(store, "sometimes", ops)
+		assert.ErrorIs(t, err, ErrInvalidBatch)
+
+		items, err := BatchByType(store, BatchBestEffort, []BatchOperation[types.Ingredient]{{Op: "move"}})
+		require.NoError(t, err)
+		assert.ErrorIs(t, items[0].Err, ErrInvalidBatch)
+	})
+}
+
+func TestRouteBatchRecipes(t *testing.T) {
+	t.Setenv("ADMIN_KEY", "secret")
+	store, router := newTestEnv(t)
+	existing, err := CreateByType(store, recipeGenerator.Generate())
+	require.NoError(t, err)
+
+	added := recipeGenerator.Generate()
+	added.RecipeSteps = []types.RecipeStep{{Step: "stir"}}
+	missing := recipeGenerator.Generate()
+	missing.ID = "does-not-exist"
+	missing.Version = 1
+
+	batch := BatchRequest[types.Recipe]{
+		Mode: BatchBestEffort,
+		Operations: []BatchOperation[types.Recipe]{
+			{Op: BatchAdd, Value: added},
+			{Op: BatchReplace, Value: missing},
+			{Op: BatchRemove, ID: existing},
+		},
+	}
+	body, _ := json.Marshal(batch)
+	req := httptest.NewRequest("POST", "/recipes/batch", bytes.NewBuffer(body))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusMultiStatus, resp.Code)
+
+	var got BatchResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	assert.Equal(t, 2, got.Succeeded)
+	assert.Equal(t, 1, got.Failed)
+	require.Len(t, got.Results, 3)
+	assert.Equal(t, http.StatusCreated, got.Results[0].Status)
+	assert.Equal(t, http.StatusNotFound, got.Results[1].Status)
+	assert.Equal(t, http.StatusOK, got.Results[2].Status)
+
+	recipe, err := GetByTypeWithOptions[types.Recipe](store, got.Results[0].ID, QueryOptions{Include: []string{"steps"}})
+	require.NoError(t, err)
+	assert.Len(t, recipe.RecipeSteps, 1)
+
+	_, err = GetByType[types.Recipe](store, existing)
+	assert.Error(t, err, "removed recipe is in the trash")
+
+	batch.Mode = BatchAtomic
+	batch.Operations = []BatchOperation[types.Recipe]{
+		{Op: BatchAdd, Value: recipeGenerator.Generate()},
+		{Op: BatchReplace, Value: missing},
+		{Op: BatchAdd, Value: recipeGenerator.Generate()},
+	}
+	body, _ = json.Marshal(batch)
+	req = httptest.NewRequest("POST", "/recipes/batch", bytes.NewBuffer(body))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusMultiStatus, resp.Code)
+
+	got = BatchResponse{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	assert.Equal(t, 2, got.Failed)
+	assert.Equal(t, 1, got.NotAttempted)
+	require.Len(t, got.Results, 3)
+	assert.Equal(t, "/problems/batch-aborted", got.Results[0].Type, "the first operation ran and was rolled back")
+	assert.Equal(t, http.StatusNotFound, got.Results[1].Status)
+	assert.Equal(t, "/problems/batch-not-attempted", got.Results[2].Type)
+	assert.Equal(t, http.StatusFailedDependency, got.Results[2].Status)
+
+	batch.Operations = batch.Operations[:1]
+	body, _ = json.Marshal(batch)
+	req = httptest.NewRequest("POST", "/recipes/batch", bytes.NewBuffer(body))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	body, _ = json.Marshal(BatchRequest[types.Ingredient]{Mode: "sometimes"})
+	req = httptest.NewRequest("POST", "/ingredients/batch", bytes.NewBuffer(body))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code, "the catalog is only changed by admins")
+
+	req = httptest.NewRequest("POST", "/ingredients/batch", bytes.NewBuffer(body))
+	req.Header.Set(middleware.AdminKeyHeader, "secret")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor points just past the last row of a page. It is handed to clients
+// base64 encoded and should be treated as opaque by them.
+type cursor struct {
+	OrderBy string          `json:"o"`
+	Value   json.RawMessage `json:"v"`
+	ID      string          `json:"id"`
+}
+
+func cursorOrderBy(orderBy string) string {
+	if orderBy == "" {
+		return "id"
+	}
+	return orderBy
+}
+
+func fieldByColumn(t reflect.Type, column string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if col, ok := columnTag(field); ok && col == column {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// EncodeCursor returns the cursor for the page that starts after obj when
+// sorting by orderBy.
+func EncodeCursor(obj any, orderBy string) (string, error) {
+	orderBy = cursorOrderBy(orderBy)
+	v := reflect.ValueOf(obj)
+
+	field, ok := fieldByColumn(v.Type(), orderBy)
+	if !ok {
+		return "", ErrNotValidOrderBy
+	}
+	idField, ok := fieldByColumn(v.Type(), "id")
+	if !ok {
+		return "", ErrNoIdForType
+	}
+
+	value, err := json.Marshal(v.FieldByIndex(field.Index).Interface())
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(cursor{
+		OrderBy: orderBy,
+		Value:   value,
+		ID:      v.FieldByIndex(idField.Index).String(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor returns the order value and id stored in encoded, typed like
+// the orderBy field of t.
+func decodeCursor(t reflect.Type, encoded string, orderBy string) (any, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+
+	if c.OrderBy != cursorOrderBy(orderBy) {
+		return nil, "", fmt.Errorf("%w: cursor was made for order_by %s", ErrInvalidCursor, c.OrderBy)
+	}
+
+	field, ok := fieldByColumn(t, c.OrderBy)
+	if !ok {
+		return nil, "", ErrNotValidOrderBy
+	}
+
+	value := reflect.New(field.Type)
+	if err := json.Unmarshal(c.Value, value.Interface()); err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+
+	return value.Elem().Interface(), c.ID, nil
+}
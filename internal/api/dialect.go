@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect hides the SQL differences between the databases the query builder
+// can target.
+type Dialect interface {
+	// Placeholder is the bind parameter of the n-th argument, counting from 1.
+	Placeholder(n int) string
+	// Quote quotes a table or column name.
+	Quote(identifier string) string
+	// Like is the operator used for case insensitive pattern matching.
+	Like() string
+	// Upsert is appended to an INSERT so a row clashing on the conflict
+	// columns updates the update columns instead, or is skipped when there
+	// are none.
+	Upsert(conflict []string, update []string) string
+	// Returning is appended to a write to read columns of the written rows.
+	Returning(columns []string) string
+	// InsertionOrder sorts the rows of table in the order they were written.
+	InsertionOrder(table string) string
+}
+
+var (
+	SQLite     Dialect = sqliteDialect{}
+	PostgreSQL Dialect = postgresDialect{}
+)
+
+// dialectOf picks the dialect from the driver behind q, falling back to
+// SQLite.
+func dialectOf(q any) Dialect {
+	if driver, ok := q.(interface{ DriverName() string }); ok {
+		switch driver.DriverName() {
+		case "postgres", "pgx":
+			return PostgreSQL
+		}
+	}
+	return SQLite
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Placeholder(int) string { return "?" }
+
+func (sqliteDialect) Quote(identifier string) string { return quoteIdentifier(identifier) }
+
+// Like relies on SQLite's LIKE ignoring case for ASCII letters.
+func (sqliteDialect) Like() string { return "LIKE" }
+
+func (d sqliteDialect) Upsert(conflict []string, update []string) string {
+	return onConflict(d, conflict, update)
+}
+
+// Returning needs SQLite 3.35 or newer.
+func (d sqliteDialect) Returning(columns []string) string {
+	return returning(d, columns)
+}
+
+func (d sqliteDialect) InsertionOrder(table string) string {
+	return d.Quote(table) + ".rowid"
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Placeholder(n int) string { return fmt.Sprintf("$%d", n) }
+
+func (postgresDialect) Quote(identifier string) string { return quoteIdentifier(identifier) }
+
+func (postgresDialect) Like() string { return "ILIKE" }
+
+func (d postgresDialect) Upsert(conflict []string, update []string) string {
+	return onConflict(d, conflict, update)
+}
+
+func (d postgresDialect) Returning(columns []string) string {
+	return returning(d, columns)
+}
+
+// InsertionOrder uses the physical row position, which only follows the
+// insertion order until rows are updated or the table is vacuumed.
+func (d postgresDialect) InsertionOrder(table string) string {
+	return d.Quote(table) + ".ctid"
+}
+
+func quoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func quoteAll(d Dialect, identifiers []string) []string {
+	quoted := make([]string, len(identifiers))
+	for i, identifier := range identifiers {
+		quoted[i] = d.Quote(identifier)
+	}
+	return quoted
+}
+
+// placeholders returns count bind parameters starting after offset.
+func placeholders(d Dialect, offset int, count int) []string {
+	params := make([]string, count)
+	for i := range params {
+		params[i] = d.Placeholder(offset + i + 1)
+	}
+	return params
+}
+
+// Both SQLite and PostgreSQL understand the same ON CONFLICT clause.
+func onConflict(d Dialect, conflict []string, update []string) string {
+	clause := fmt.Sprintf(" ON CONFLICT (%s)", strings.Join(quoteAll(d, conflict), ", "))
+	if len(update) == 0 {
+		return clause + " DO NOTHING"
+	}
+
+	assignments := make([]string, len(update))
+	for i, col := range update {
+		assignments[i] = fmt.Sprintf("%s = excluded.%s", d.Quote(col), d.Quote(col))
+	}
+	return clause + " DO UPDATE SET " + strings.Join(assignments, ", ")
+}
+
+func returning(d Dialect, columns []string) string {
+	if len(columns) == 0 {
+		return ""
+	}
+	return " RETURNING " + strings.Join(quoteAll(d, columns), ", ")
+}
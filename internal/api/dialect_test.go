@@ -0,0 +1,103 @@
+package api
+
+import (
+	"flag"
+	"fmt"
+	"opskrifter-backend/internal/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// dialectQueries builds one query of every kind the query builder emits.
+func dialectQueries(t *testing.T, d Dialect) [][2]string {
+	recipe := types.Recipe{ID: "r1", Name: "Boller", Version: 3}
+	cursor, err := EncodeCursor(types.Recipe{ID: "b", Minutes: 20}, "minutes")
+	require.NoError(t, err)
+
+	filters := []Filter{
+		{Field: "minutes", Op: "lte", Values: []string{"30"}},
+		{Field: "name", Op: "like", Values: []string{"bolle"}},
+		{Field: "recipe_cuisine", Op: "in", Values: []string{"dansk", "italiensk"}},
+	}
+
+	var queries [][2]string
+	add := func(name string, query string, err error) {
+		require.NoError(t, err, name)
+		queries = append(queries, [2]string{name, query})
+	}
+
+	query, _, _, err := BuildInsertQuery(d, recipe)
+	add("insert", query, err)
+
+	query, _, _, err = BuildUpsertQuery(d, types.Ingredient{Name: "Agurk"}, []string{"name"})
+	add("upsert", query, err)
+
+	query, _, err = BuildUpdateQuery(d, recipe)
+	add("update", query, err)
+
+	query, _ = BuildPatchQuery(d, recipe, map[string]any{"name": "Boller", "minutes": 30})
+	add("patch", query, nil)
+
+	query, _, err = BuildQuery(d, types.Recipe{}, QueryOptions{Page: 2, PerPage: 10, OrderBy: "minutes", Filters: filters})
+	add("list", query, err)
+
+	query, _, err = BuildQuery(d, types.Recipe{}, QueryOptions{PerPage: 10, OrderBy: "minutes", Fields: []string{"name"}, UseCursor: true, Cursor: cursor})
+	add("list cursor", query, err)
+
+	query, _, err = BuildCountQuery(d, types.Recipe{}, QueryOptions{Filters: filters})
+	add("count", query, err)
+
+	query, _, err = BuildQuery(d, types.Recipe{}, QueryOptions{PerPage: 10, Filters: filters[:1], UseCursor: true, Cursor: cursor, OrderBy: "minutes",
+		ExcludeIngredients: []string{"nuts", "shrimp"}, RequireIngredients: []string{"flour"}})
+	add("list ingredients", query, err)
+
+	query, _, err = BuildQueryRelationsByType(d, "r1", []types.RecipeIngredient{{IngredientId: "1"}, {IngredientId: "2"}})
+	add("insert relations", query, err)
+
+	rels, err := resolveRelations(reflect.TypeOf(types.Recipe{}), []string{"ingredients"})
+	require.NoError(t, err)
+	add("load relations", rels[0].selectQuery(d, 2), nil)
+
+	return queries
+}
+
+func TestDialectGolden(t *testing.T) {
+	dialects := map[string]Dialect{
+		"sqlite":     SQLite,
+		"postgresql": PostgreSQL,
+	}
+
+	for name, d := range dialects {
+		t.Run(name, func(t *testing.T) {
+			var out strings.Builder
+			for _, q := range dialectQueries(t, d) {
+				fmt.Fprintf(&out, "-- %s\n%s\n\n", q[0], q[1])
+			}
+
+			path := filepath.Join("testdata", "dialect", name+".sql.golden")
+			if *updateGolden {
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+				require.NoError(t, os.WriteFile(path, []byte(out.String()), 0o644))
+			}
+
+			want, err := os.ReadFile(path)
+			require.NoError(t, err, "run go test -update to create the golden file")
+			assert.Equal(t, string(want), out.String())
+		})
+	}
+}
+
+func TestDialectOf(t *testing.T) {
+	store := newTestStore(t)
+	assert.Equal(t, SQLite, dialectOf(store))
+	assert.Equal(t, SQLite, dialectOf(nil))
+	assert.Equal(t, `"odd""name"`, PostgreSQL.Quote(`odd"name`))
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+var ErrNotValidField = errors.New("this field does not exist")
+
+// jsonName is the key a field is encoded under.
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// parseFields reads the comma separated column names of ?fields=.
+func parseFields(raw string) []string {
+	var fields []string
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// selectColumns builds the column list of a SELECT for the requested fields
+// of t. The required columns are always selected, since relations and
+// cursors are built from them. No fields selects every column.
+func selectColumns(d Dialect, t reflect.Type, fields []string, required ...string) (string, error) {
+	if len(fields) == 0 {
+		return "*", nil
+	}
+
+	var columns []string
+	for _, field := range fields {
+		if _, ok := fieldByColumn(t, field); !ok {
+			return "", fmt.Errorf("%w: %s", ErrNotValidField, field)
+		}
+		if !slices.Contains(columns, field) {
+			columns = append(columns, field)
+		}
+	}
+
+	for _, col := range required {
+		if !slices.Contains(columns, col) {
+			columns = append(columns, col)
+		}
+	}
+
+	return strings.Join(quoteAll(d, columns), ", "), nil
+}
+
+// projectFields encodes items as JSON objects holding only the requested
+// columns and the included relations.
+func projectFields[T any](items []T, fields []string, include []string) ([]map[string]any, error) {
+	var zero T
+	t := reflect.TypeOf(zero)
+	keep := map[string]bool{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if col, ok := columnTag(field); ok && slices.Contains(fields, col) {
+			keep[jsonName(field)] = true
+		}
+	}
+	for _, rel := range relationsOf(t) {
+		if slices.Contains(include, rel.name) {
+			keep[jsonName(t.Field(rel.index))] = true
+		}
+	}
+
+	projected := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+
+		var obj map[string]any
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&obj); err != nil {
+			return nil, err
+		}
+
+		for key := range obj {
+			if !keep[key] {
+				delete(obj, key)
+			}
+		}
+		projected = append(projected, obj)
+	}
+
+	return projected, nil
+}
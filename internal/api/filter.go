@@ -0,0 +1,174 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrUnknownFilterField = errors.New("unknown filter field")
+var ErrFilterNotAllowed = errors.New("filter operator not allowed")
+var ErrInvalidFilterValue = errors.New("invalid filter value")
+
+// FilterError is returned for a filter that cannot be turned into SQL.
+type FilterError struct {
+	Field string
+	Op    string
+	Err   error
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("filter %s[%s]: %v", e.Field, e.Op, e.Err)
+}
+
+func (e *FilterError) Unwrap() error { return e.Err }
+
+// Filter is one condition from the query string, e.g. minutes[lte]=30.
+type Filter struct {
+	Field  string   `json:"field"`
+	Op     string   `json:"op"`
+	Values []string `json:"values"`
+}
+
+var filterOperators = map[string]string{
+	"eq":   "=",
+	"ne":   "!=",
+	"lt":   "<",
+	"lte":  "<=",
+	"gt":   ">",
+	"gte":  ">=",
+	"in":   "IN",
+	"like": "LIKE",
+}
+
+// reservedParams are query parameters that are never filters.
+var reservedParams = map[string]bool{
+	"page":                true,
+	"per_page":            true,
+	"order_by":            true,
+	"include":             true,
+	"fields":              true,
+	"cursor":              true,
+	"q":                   true,
+	"ingredients":         true,
+	"max_missing":         true,
+	"exclude_ingredients": true,
+	"require_ingredients": true,
+}
+
+// ParseFilters reads field=value and field[op]=value pairs from the query
+// string. Values of the in operator are comma separated.
+func ParseFilters(query url.Values) ([]Filter, error) {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		if !reservedParams[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var filters []Filter
+	for _, key := range keys {
+		field, op := key, "eq"
+		if i := strings.Index(key, "["); i >= 0 {
+			if !strings.HasSuffix(key, "]") {
+				return nil, &FilterError{Field: key, Op: op, Err: ErrFilterNotAllowed}
+			}
+			field, op = key[:i], key[i+1:len(key)-1]
+		}
+
+		for _, value := range query[key] {
+			values := []string{value}
+			if op == "in" {
+				values = strings.Split(value, ",")
+			}
+			filters = append(filters, Filter{Field: field, Op: op, Values: values})
+		}
+	}
+
+	return filters, nil
+}
+
+// filterableFields maps the column of every field with a filter tag to the
+// field and the operators the tag allows, e.g. filter:"eq,lt,lte".
+func filterableFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		col, ok := columnTag(field)
+		if ok && field.Tag.Get("filter") != "" {
+			fields[col] = field
+		}
+	}
+	return fields
+}
+
+func filterValue(field reflect.StructField, raw string) (any, error) {
+	switch field.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(raw, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(raw, 64)
+	case reflect.Bool:
+		return strconv.ParseBool(raw)
+	}
+	return raw, nil
+}
+
+// buildFilterConditions turns filters into SQL conditions for t that are
+// meant to be joined with AND. Placeholders are numbered from argOffset+1.
+func buildFilterConditions(d Dialect, t reflect.Type, filters []Filter, argOffset int) ([]string, []any, error) {
+	if len(filters) == 0 {
+		return nil, nil, nil
+	}
+
+	fields := filterableFields(t)
+	var conditions []string
+	var args []any
+
+	for _, f := range filters {
+		field, ok := fields[f.Field]
+		if !ok {
+			return nil, nil, &FilterError{Field: f.Field, Op: f.Op, Err: ErrUnknownFilterField}
+		}
+
+		sqlOp, known := filterOperators[f.Op]
+		allowed := strings.Split(field.Tag.Get("filter"), ",")
+		if !known || !slices.Contains(allowed, f.Op) {
+			return nil, nil, &FilterError{Field: f.Field, Op: f.Op, Err: ErrFilterNotAllowed}
+		}
+
+		var placeholders []string
+		for _, raw := range f.Values {
+			value, err := filterValue(field, raw)
+			if err != nil {
+				return nil, nil, &FilterError{Field: f.Field, Op: f.Op, Err: ErrInvalidFilterValue}
+			}
+
+			if f.Op == "like" {
+				escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(raw)
+				value = "%" + escaped + "%"
+			}
+
+			args = append(args, value)
+			placeholders = append(placeholders, d.Placeholder(argOffset+len(args)))
+		}
+
+		column := d.Quote(f.Field)
+		switch f.Op {
+		case "in":
+			conditions = append(conditions, fmt.Sprintf("%s IN (%s)", column, strings.Join(placeholders, ", ")))
+		case "like":
+			conditions = append(conditions, fmt.Sprintf(`%s %s %s ESCAPE '\'`, column, d.Like(), placeholders[0]))
+		default:
+			conditions = append(conditions, fmt.Sprintf("%s %s %s", column, sqlOp, placeholders[0]))
+		}
+	}
+
+	return conditions, args, nil
+}
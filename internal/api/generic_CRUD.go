@@ -1,37 +1,82 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"opskrifter-backend/internal/types"
 	"opskrifter-backend/pkg/myDB"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
 )
 
-func DeleteByType[T types.Identifiable](id string) (string, error) {
+func DeleteByType[T types.Identifiable](q myDB.Querier, id string) (string, error) {
+	return DeleteByTypeContext[T](context.Background(), q, id)
+}
+
+// DeleteByTypeContext answers sql.ErrNoRows when there is no row with id left
+// to delete, which includes rows already in the trash.
+func DeleteByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, id string) (string, error) {
 	var obj T
-	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", obj.TableName())
-	sqlResult, err := myDB.DB.Exec(query, id)
+	d := dialectOf(q)
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", d.Quote(obj.TableName()), d.Quote("id"), d.Placeholder(1))
+	args := []any{id}
+
+	if col, ok := softDeleteColumn(reflect.TypeOf(obj)); ok {
+		args = []any{deletedAt(time.Now()), id}
+		query = fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s = %s AND %s IS NULL", d.Quote(obj.TableName()),
+			d.Quote(col), d.Placeholder(1), d.Quote("id"), d.Placeholder(2), d.Quote(col))
+	}
+
+	field, versioned := versionColumn(reflect.TypeOf(obj))
+	expected, conditional := expectedVersions(ctx)
+	if versioned && conditional {
+		col, _ := columnTag(field)
+		for _, version := range expected {
+			args = append(args, version)
+		}
+		query += fmt.Sprintf(" AND %s IN (%s)", d.Quote(col), strings.Join(placeholders(d, len(args)-len(expected), len(expected)), ", "))
+	}
+
+	sqlResult, err := q.ExecContext(ctx, query, args...)
 	if err != nil {
 		return "", fmt.Errorf("failed to delete: %w", err)
 	}
 
-	_, err = sqlResult.RowsAffected()
+	rowsAffected, err := sqlResult.RowsAffected()
 	if err != nil {
 		return "", err
 	}
+
+	if rowsAffected == 0 {
+		if versioned && conditional {
+			return "", missingOrStale(ctx, q, obj, id)
+		}
+		return "", sql.ErrNoRows
+	}
 	return id, nil
 }
 
-func DeleteRelationByType[R types.ManyToMany](parentID string, childID string) error {
+func DeleteRelationByType[R types.ManyToMany](q myDB.Querier, parentID string, childID string) error {
+	return DeleteRelationByTypeContext[R](context.Background(), q, parentID, childID)
+}
+
+func DeleteRelationByTypeContext[R types.ManyToMany](ctx context.Context, q myDB.Querier, parentID string, childID string) error {
 	var r R
 	colNames, err := GetColumnNames(r)
 	if err != nil {
 		return err
 	}
 
-	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s = ?", r.TableName(), colNames[0], colNames[1])
-	sqlResult, err := myDB.DB.Exec(query, parentID, childID)
+	d := dialectOf(q)
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = %s AND %s = %s", d.Quote(r.TableName()),
+		d.Quote(colNames[0]), d.Placeholder(1),
+		d.Quote(colNames[1]), d.Placeholder(2),
+	)
+	sqlResult, err := q.ExecContext(ctx, query, parentID, childID)
 	if err != nil {
 		return fmt.Errorf("failed to delete: %w", err)
 	}
@@ -43,9 +88,21 @@ func DeleteRelationByType[R types.ManyToMany](parentID string, childID string) e
 	return nil
 }
 
-func CreateByType[T types.Identifiable](obj T) (string, error) {
-	query, args, id := BuildInsertQuery(obj)
-	result, err := myDB.DB.Exec(query, args...)
+func CreateByType[T types.Identifiable](q myDB.Querier, obj T) (string, error) {
+	return CreateByTypeContext[T](context.Background(), q, obj)
+}
+
+func CreateByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, obj T) (string, error) {
+	if err := Validate(obj); err != nil {
+		return "", err
+	}
+
+	query, args, id, err := BuildInsertQuery(dialectOf(q), obj)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := q.ExecContext(ctx, query, args...)
 	if err != nil {
 		return "", fmt.Errorf("failed to execute insert: %w (query: %q)", err, query)
 	}
@@ -62,11 +119,40 @@ func CreateByType[T types.Identifiable](obj T) (string, error) {
 	return id, nil
 }
 
-func CreateByTypeWithRelations[T types.IdentifiableWithRelations](obj T) (string, error) {
-	id, err := CreateByType(obj)
+func CreateByTypeWithRelations[T types.IdentifiableWithRelations](q myDB.Querier, obj T) (string, error) {
+	return CreateByTypeWithRelationsContext[T](context.Background(), q, obj)
+}
+
+func CreateByTypeWithRelationsContext[T types.IdentifiableWithRelations](ctx context.Context, q myDB.Querier, obj T) (string, error) {
+	var id string
+	err := myDB.WithTxContext(ctx, q, func(tx myDB.Querier) error {
+		var err error
+		id, err = createByTypeWithRelations(ctx, tx, obj)
+		return err
+	})
 
 	if err != nil {
-		return "", fmt.Errorf("error creating object")
+		return "", err
+	}
+
+	return id, nil
+}
+
+func createByTypeWithRelations[T types.IdentifiableWithRelations](ctx context.Context, q myDB.Querier, obj T) (string, error) {
+	if err := Validate(obj); err != nil {
+		return "", err
+	}
+
+	obj, err := resolveJoinedKeys(ctx, q, obj)
+	if err != nil {
+		return "", err
+	}
+	obj = numberRows(obj)
+
+	id, err := CreateByTypeContext(ctx, q, obj)
+
+	if err != nil {
+		return "", fmt.Errorf("error creating object: %w", err)
 	}
 
 	if id == "" {
@@ -76,7 +162,7 @@ func CreateByTypeWithRelations[T types.IdentifiableWithRelations](obj T) (string
 	manyrelations := obj.GetManyToMany()
 
 	for i := range manyrelations {
-		err = CreateManyToManyByType(id, manyrelations[i])
+		err = CreateManyToManyByTypeContext(ctx, q, id, manyrelations[i])
 		if err != nil {
 			return "", err
 		}
@@ -85,7 +171,7 @@ func CreateByTypeWithRelations[T types.IdentifiableWithRelations](obj T) (string
 	onerelations := obj.GetOneToMany()
 
 	for i := range onerelations {
-		err = CreateManyToManyByType("", onerelations[i])
+		err = CreateManyToManyByTypeContext(ctx, q, id, onerelations[i])
 		if err != nil {
 			return "", err
 		}
@@ -94,30 +180,200 @@ func CreateByTypeWithRelations[T types.IdentifiableWithRelations](obj T) (string
 	return id, nil
 }
 
-func UpdateByType[T types.Identifiable](obj T) (string, error) {
-	query, args := BuildUpdateQuery(obj)
-	sqlResult, err := myDB.DB.Exec(query, args...)
+func UpdateByType[T types.Identifiable](q myDB.Querier, obj T) (string, error) {
+	return UpdateByTypeContext[T](context.Background(), q, obj)
+}
+
+// UpdateByTypeContext only updates versioned types when the stored version
+// matches the one expected by ctx, or else the one in obj when it is set.
+func UpdateByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, obj T) (string, error) {
+	if err := Validate(obj); err != nil {
+		return "", err
+	}
+
+	if versions, ok := expectedVersions(ctx); ok {
+		expected, err := expectedVersion(ctx, q, obj, obj.GetID(), versions)
+		if err != nil {
+			return "", err
+		}
+		setVersion(&obj, expected)
+	}
+
+	query, args, err := BuildUpdateQuery(dialectOf(q), obj)
+	if err != nil {
+		return "", err
+	}
 
+	sqlResult, err := q.ExecContext(ctx, query, args...)
 	if err != nil {
 		return "", fmt.Errorf("failed to update: %w", err)
 	}
 
-	_, err = sqlResult.RowsAffected()
+	rowsAffected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+
+	if rowsAffected == 0 {
+		if version, ok := versionOf(obj); ok && version > 0 {
+			return "", missingOrStale(ctx, q, obj, obj.GetID())
+		}
+		return "", sql.ErrNoRows
+	}
+
+	return obj.GetID(), nil
+}
+
+func UpdateByTypeWithRelations[T types.IdentifiableWithRelations](q myDB.Querier, obj T) (string, error) {
+	return UpdateByTypeWithRelationsContext[T](context.Background(), q, obj)
+}
+
+func UpdateByTypeWithRelationsContext[T types.IdentifiableWithRelations](ctx context.Context, q myDB.Querier, obj T) (string, error) {
+	err := myDB.WithTxContext(ctx, q, func(tx myDB.Querier) error {
+		return updateByTypeWithRelations(ctx, tx, obj)
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return obj.GetID(), nil
+}
+
+func updateByTypeWithRelations[T types.IdentifiableWithRelations](ctx context.Context, q myDB.Querier, obj T) error {
+	if obj.GetID() == "" {
+		return ErrNoIdForType
+	}
+
+	if err := Validate(obj); err != nil {
+		return err
+	}
+
+	obj, err := resolveJoinedKeys(ctx, q, obj)
+	if err != nil {
+		return err
+	}
+
+	// Rows naming the same child only share a key once it is resolved.
+	if err := Validate(obj); err != nil {
+		return err
+	}
+
+	if versions, ok := expectedVersions(ctx); ok {
+		expected, err := expectedVersion(ctx, q, obj, obj.GetID(), versions)
+		if err != nil {
+			return err
+		}
+		setVersion(&obj, expected)
+	}
+
+	query, args, err := BuildUpdateQuery(dialectOf(q), obj)
+	if err != nil {
+		return err
+	}
+
+	sqlResult, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update: %w", err)
+	}
+
+	rowsAffected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		if version, ok := versionOf(obj); ok && version > 0 {
+			return missingOrStale(ctx, q, obj, obj.GetID())
+		}
+		return sql.ErrNoRows
+	}
+
+	return syncRelations(ctx, q, obj)
+}
+
+// PatchByType applies patch to the stored object and writes back only the
+// columns it changed.
+func PatchByType[T types.Identifiable](q myDB.Querier, id string, patch Patch) (string, error) {
+	return PatchByTypeContext[T](context.Background(), q, id, patch)
+}
+
+func PatchByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, id string, patch Patch) (string, error) {
+	err := myDB.WithTxContext(ctx, q, func(tx myDB.Querier) error {
+		return patchByType[T](ctx, tx, id, patch)
+	})
+
 	if err != nil {
 		return "", err
 	}
 
-	return obj.GetID(), err
+	return id, nil
 }
 
-func UpdateCountByType[T types.Identifiable](obj T, updateCol string, delta string) error {
+func patchByType[T types.Identifiable](ctx context.Context, q myDB.Querier, id string, patch Patch) error {
+	current, err := GetByTypeContext[T](ctx, q, id)
+	if err != nil {
+		return err
+	}
+
+	if expected, ok := expectedVersions(ctx); ok {
+		if version, versioned := versionOf(current); versioned && !slices.Contains(expected, version) {
+			return ErrVersionMismatch
+		}
+	}
+
+	changes, err := patchColumns(current, patch)
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := Validate(patched(current, changes)); err != nil {
+		return err
+	}
+	if err := refold(patched(current, changes), changes); err != nil {
+		return err
+	}
+
+	query, args := BuildPatchQuery(dialectOf(q), current, changes)
+	sqlResult, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to patch: %w", err)
+	}
+
+	rowsAffected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		// The row changed or went away between reading and writing it.
+		if _, versioned := versionOf(current); versioned {
+			return ErrVersionMismatch
+		}
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func UpdateCountByType[T types.Identifiable](q myDB.Querier, obj T, updateCol string, delta string) error {
+	return UpdateCountByTypeContext[T](context.Background(), q, obj, updateCol, delta)
+}
+
+func UpdateCountByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, obj T, updateCol string, delta string) error {
 	id := obj.GetID()
 	if id == "" {
 		return ErrNoIdForType
 	}
 
-	query := fmt.Sprintf("UPDATE %s SET %s = %s %s WHERE id = ?", obj.TableName(), updateCol, updateCol, delta)
-	sqlResult, err := myDB.DB.Exec(query, obj.GetID())
+	d := dialectOf(q)
+	column := d.Quote(updateCol)
+	query := fmt.Sprintf("UPDATE %s SET %s = %s %s WHERE %s = %s", d.Quote(obj.TableName()), column, column, delta, d.Quote("id"), d.Placeholder(1)) + andLive(d, reflect.TypeOf(obj))
+	sqlResult, err := q.ExecContext(ctx, query, obj.GetID())
 
 	if err != nil {
 		return fmt.Errorf("failed to update count: %w", err)
@@ -131,22 +387,68 @@ func UpdateCountByType[T types.Identifiable](obj T, updateCol string, delta stri
 	return nil
 }
 
-func GetByType[T types.Identifiable](id string) (T, error) {
+func GetByType[T types.Identifiable](q myDB.Querier, id string) (T, error) {
+	return GetByTypeContext[T](context.Background(), q, id)
+}
+
+func GetByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, id string) (T, error) {
 	var obj T
-	query := fmt.Sprintf("SELECT * FROM %s WHERE id = ?", obj.TableName())
-	err := myDB.DB.Get(&obj, query, id)
+	d := dialectOf(q)
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s = %s", d.Quote(obj.TableName()), d.Quote("id"), d.Placeholder(1)) + andLive(d, reflect.TypeOf(obj))
+	err := q.GetContext(ctx, &obj, query, id)
 	return obj, err
 }
 
-func GetRelationByType[R types.ManyToMany](parentID string, childID string) (R, error) {
+// GetByTypeWithOptions reads only opts.Fields, or every column when empty,
+// and loads the relations in opts.Include.
+func GetByTypeWithOptions[T types.Identifiable](q myDB.Querier, id string, opts QueryOptions) (T, error) {
+	return GetByTypeWithOptionsContext[T](context.Background(), q, id, opts)
+}
+
+func GetByTypeWithOptionsContext[T types.Identifiable](ctx context.Context, q myDB.Querier, id string, opts QueryOptions) (T, error) {
+	var obj T
+	d := dialectOf(q)
+	required := []string{"id"}
+	if field, ok := versionColumn(reflect.TypeOf(obj)); ok {
+		col, _ := columnTag(field)
+		required = append(required, col)
+	}
+
+	columns, err := selectColumns(d, reflect.TypeOf(obj), opts.Fields, required...)
+	if err != nil {
+		return obj, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s", columns, d.Quote(obj.TableName()), d.Quote("id"), d.Placeholder(1)) + andLive(d, reflect.TypeOf(obj))
+	if err := q.GetContext(ctx, &obj, query, id); err != nil {
+		return obj, err
+	}
+
+	objs := []T{obj}
+	if err := loadRelations(ctx, q, objs, opts.Include); err != nil {
+		return obj, err
+	}
+
+	return objs[0], nil
+}
+
+func GetRelationByType[R types.ManyToMany](q myDB.Querier, parentID string, childID string) (R, error) {
+	return GetRelationByTypeContext[R](context.Background(), q, parentID, childID)
+}
+
+func GetRelationByTypeContext[R types.ManyToMany](ctx context.Context, q myDB.Querier, parentID string, childID string) (R, error) {
 	var r R
 	colNames, err := GetColumnNames(r)
 	if err != nil {
 		return r, err
 	}
 
-	query := fmt.Sprintf("SELECT * FROM %s WHERE %s = ? AND %s = ?", r.TableName(), colNames[0], colNames[1])
-	err = myDB.DB.Get(&r, query, parentID, childID)
+	d := dialectOf(q)
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s = %s AND %s = %s", d.Quote(r.TableName()),
+		d.Quote(colNames[0]), d.Placeholder(1),
+		d.Quote(colNames[1]), d.Placeholder(2),
+	)
+	err = q.GetContext(ctx, &r, query, parentID, childID)
 
 	if errors.Is(err, sql.ErrNoRows) {
 		return r, err
@@ -159,21 +461,50 @@ func GetRelationByType[R types.ManyToMany](parentID string, childID string) (R,
 	return r, nil
 }
 
-func GetCountByType[T types.Identifiable](obj T) (int, error) {
+func GetCountByType[T types.Identifiable](q myDB.Querier, obj T) (int, error) {
+	return GetCountByTypeContext[T](context.Background(), q, obj)
+}
+
+func GetCountByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, obj T) (int, error) {
+	count := 0
+	d := dialectOf(q)
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s`, d.Quote(obj.TableName())) + whereClause(liveCondition(d, reflect.TypeOf(obj), false))
+	err := q.QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
+
+func CountByType[T types.Identifiable](q myDB.Querier, opts QueryOptions) (int, error) {
+	return CountByTypeContext[T](context.Background(), q, opts)
+}
+
+func CountByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, opts QueryOptions) (int, error) {
+	var zero T
 	count := 0
-	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s`, obj.TableName())
-	err := myDB.DB.QueryRow(query).Scan(&count)
+	query, args, err := BuildCountQuery(dialectOf(q), zero, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	err = q.QueryRowContext(ctx, query, args...).Scan(&count)
 	return count, err
 }
 
-func GetCountByTable(table string) (int, error) {
+func GetCountByTable(q myDB.Querier, table string) (int, error) {
+	return GetCountByTableContext(context.Background(), q, table)
+}
+
+func GetCountByTableContext(ctx context.Context, q myDB.Querier, table string) (int, error) {
 	count := 0
-	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s`, table)
-	err := myDB.DB.QueryRow(query).Scan(&count)
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s`, dialectOf(q).Quote(table))
+	err := q.QueryRowContext(ctx, query).Scan(&count)
 	return count, err
 }
 
-func GetManyByType[T types.Identifiable](opts QueryOptions) ([]T, error) {
+func GetManyByType[T types.Identifiable](q myDB.Querier, opts QueryOptions) ([]T, error) {
+	return GetManyByTypeContext[T](context.Background(), q, opts)
+}
+
+func GetManyByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, opts QueryOptions) ([]T, error) {
 	var zero T
 	var objs []T
 	if opts.PerPage < 0 {
@@ -184,27 +515,57 @@ func GetManyByType[T types.Identifiable](opts QueryOptions) ([]T, error) {
 		return nil, fmt.Errorf("page cannot be less than 0")
 	}
 
-	query, args, err := BuildQuery(zero.TableName(), opts)
+	query, args, err := BuildQuery(dialectOf(q), zero, opts)
 
 	if err != nil {
 		return nil, err
 	}
 
-	err = myDB.DB.Select(&objs, query, args...)
+	err = q.SelectContext(ctx, &objs, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
 
+	if err := loadRelations(ctx, q, objs, opts.Include); err != nil {
+		return nil, err
+	}
+
 	return objs, nil
 }
 
-func GetAllByType[T types.Identifiable]() ([]T, error) {
+func GetAllByType[T types.Identifiable](q myDB.Querier) ([]T, error) {
+	return GetAllByTypeContext[T](context.Background(), q)
+}
+
+func GetAllByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier) ([]T, error) {
+	return GetAllByTypeWithOptionsContext[T](ctx, q, QueryOptions{})
+}
+
+// GetAllByTypeWithOptions reads every row of T, limited to opts.Fields when
+// given.
+func GetAllByTypeWithOptions[T types.Identifiable](q myDB.Querier, opts QueryOptions) ([]T, error) {
+	return GetAllByTypeWithOptionsContext[T](context.Background(), q, opts)
+}
+
+func GetAllByTypeWithOptionsContext[T types.Identifiable](ctx context.Context, q myDB.Querier, opts QueryOptions) ([]T, error) {
 	var obj T
 	var objs []T
 
+	d := dialectOf(q)
+	required := []string{"id"}
+	if field, ok := versionColumn(reflect.TypeOf(obj)); ok {
+		col, _ := columnTag(field)
+		required = append(required, col)
+	}
+
+	columns, err := selectColumns(d, reflect.TypeOf(obj), opts.Fields, required...)
+	if err != nil {
+		return objs, err
+	}
+
 	tableName := obj.TableName()
-	query := fmt.Sprintf("SELECT * FROM %s", tableName)
-	err := myDB.DB.Select(&objs, query)
+	query := fmt.Sprintf("SELECT %s FROM %s", columns, d.Quote(tableName)) + whereClause(liveCondition(d, reflect.TypeOf(obj), opts.Trashed))
+	err = q.SelectContext(ctx, &objs, query)
 
 	if err != nil {
 		return objs, err
@@ -213,39 +574,62 @@ func GetAllByType[T types.Identifiable]() ([]T, error) {
 	return objs, nil
 }
 
-func CreateManyByType[T types.Identifiable](elements []T) ([]string, error) {
+func CreateManyByType[T types.Identifiable](q myDB.Querier, elements []T) ([]string, error) {
+	return CreateManyByTypeContext[T](context.Background(), q, elements)
+}
+
+func CreateManyByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, elements []T) ([]string, error) {
 	var ids []string
-	for i := range elements {
-		id, err := CreateByType(elements[i])
-		if err != nil {
-			return nil, err
+	err := myDB.WithTxContext(ctx, q, func(tx myDB.Querier) error {
+		ids = nil
+		for i := range elements {
+			id, err := CreateByTypeContext(ctx, tx, elements[i])
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
 		}
-		ids = append(ids, id)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
+
 	return ids, nil
 }
 
-func DeleteManyByType[T types.Identifiable](ids []string) error {
-	for i := range ids {
-		_, err := DeleteByType[T](ids[i])
-		if err != nil {
-			return err
+func DeleteManyByType[T types.Identifiable](q myDB.Querier, ids []string) error {
+	return DeleteManyByTypeContext[T](context.Background(), q, ids)
+}
+
+func DeleteManyByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, ids []string) error {
+	return myDB.WithTxContext(ctx, q, func(tx myDB.Querier) error {
+		for i := range ids {
+			_, err := DeleteByTypeContext[T](ctx, tx, ids[i])
+			if err != nil {
+				return err
+			}
 		}
-	}
-	return nil
+		return nil
+	})
+}
+
+func CreateManyToManyByType[E types.OneToMany](q myDB.Querier, parentID string, elements []E) error {
+	return CreateManyToManyByTypeContext[E](context.Background(), q, parentID, elements)
 }
 
-func CreateManyToManyByType[E types.OneToMany](parentID string, elements []E) error {
+func CreateManyToManyByTypeContext[E types.OneToMany](ctx context.Context, q myDB.Querier, parentID string, elements []E) error {
 	if len(elements) == 0 {
 		return nil
 	}
 
-	query, args, err := BuildQueryRelationsByType(parentID, elements)
+	query, args, err := BuildQueryRelationsByType(dialectOf(q), parentID, elements)
 	if err != nil {
 		return err
 	}
 
-	_, err = myDB.DB.Exec(query, args...)
+	_, err = q.ExecContext(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("query failed: %w", err)
 	}
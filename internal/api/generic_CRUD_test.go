@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"opskrifter-backend/internal/testutils"
 	"opskrifter-backend/internal/types"
 	"testing"
@@ -10,17 +12,18 @@ import (
 )
 
 func TestDeleteGeneric(t *testing.T) {
-	id, err := CreateByType(testRecipe)
+	store := newTestStore(t)
+	id, err := CreateByType(store, testRecipe)
 	require.NotEmpty(t, id, "failed to create an ID")
 	require.NoError(t, err, "failed to insert recipe for delete test")
 
 	testRecipe.ID = id
-	_, err = DeleteByType[types.Recipe](id)
+	_, err = DeleteByType[types.Recipe](store, id)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = testutils.AssertCountByType[types.Recipe](0, GetCountByType)
+	err = testutils.AssertCountByType[types.Recipe](store, 0, GetCountByType[types.Recipe])
 
 	if err != nil {
 		t.Fatalf("failed to get the count %v", err)
@@ -28,37 +31,40 @@ func TestDeleteGeneric(t *testing.T) {
 }
 
 func TestGetGeneric(t *testing.T) {
-	id, err := CreateByType(testRecipe)
+	store := newTestStore(t)
+	id, err := CreateByType(store, testRecipe)
 
 	require.NotEmpty(t, id, "failed to create an ID")
 	require.NoError(t, err, "failed to insert recipe for get test")
 
 	testRecipe.ID = id
-	obj, err := GetByType[types.Recipe](id)
+	obj, err := GetByType[types.Recipe](store, id)
 
 	require.NoError(t, err, "failed to get recipe")
 	assert.Equal(t, testRecipe.GetID(), obj.GetID(), "unexpected recipe ID")
 	assert.Equal(t, testRecipe.Name, obj.Name, "unexpected recipe Name")
 
-	_, err = DeleteByType[types.Recipe](id)
+	_, err = DeleteByType[types.Recipe](store, id)
 	if err != nil {
 		t.Fatalf("failed to clean up recipe: %v", err)
 	}
 }
 
 func TestCreateGeneric(t *testing.T) {
-	id, err := CreateByType(testRecipe)
+	store := newTestStore(t)
+	id, err := CreateByType(store, testRecipe)
 	require.NoError(t, err, "failed to insert recipe")
 	require.NotEmpty(t, id, "failed to create an ID")
-	err = testutils.AssertCountByType[types.Recipe](1, GetCountByType)
+	err = testutils.AssertCountByType[types.Recipe](store, 1, GetCountByType[types.Recipe])
 	require.NoError(t, err, "failed to get the count")
 
-	_, err = DeleteByType[types.Recipe](id)
+	_, err = DeleteByType[types.Recipe](store, id)
 	require.NoError(t, err, "failed to clean up recipe")
 }
 
 func TestUpdateGeneric(t *testing.T) {
-	id, err := CreateByType(testRecipe)
+	store := newTestStore(t)
+	id, err := CreateByType(store, testRecipe)
 
 	require.NotEmpty(t, id, "failed to create an ID")
 	require.NoError(t, err, "failed to insert recipe for update test")
@@ -69,50 +75,54 @@ func TestUpdateGeneric(t *testing.T) {
 	testRecipe.Description = "Updated Description"
 	testRecipe.Image = "after.jpg"
 	testRecipe.Likes = 42
+	testRecipe.CreatedAt = "1999-01-01T00:00:00Z"
 	testRecipe.ID = id
 
-	_, err = UpdateByType(testRecipe)
+	_, err = UpdateByType(store, testRecipe)
 	require.NoError(t, err, "failed to update recipe")
 
-	updated, err := GetByType[types.Recipe](id)
+	updated, err := GetByType[types.Recipe](store, id)
 	require.NoError(t, err, "failed to fetch updated recipe")
 
 	assert.Equal(t, "Updated Recipe", updated.Name, "name was not updated correctly")
 	assert.Equal(t, "Updated Description", updated.Description, "description was not updated correctly")
 	assert.Equal(t, "after.jpg", updated.Image, "image was not updated correctly")
-	assert.Equal(t, 42, updated.Likes, "likes was not updated correctly")
+	assert.Equal(t, 0, updated.Likes, "likes is read-only")
+	assert.NotEqual(t, "1999-01-01T00:00:00Z", updated.CreatedAt, "created_at is set by the server")
 
-	_, err = DeleteByType[types.Recipe](id)
+	_, err = DeleteByType[types.Recipe](store, id)
 	require.NoError(t, err, "failed to clean up recipe")
 }
 
 func TestGetMany(t *testing.T) {
+	store := newTestStore(t)
 	for i := range testRecipes {
-		id, err := CreateByType(testRecipes[i])
+		id, err := CreateByType(store, testRecipes[i])
 		testRecipes[i].ID = id
 		require.NoErrorf(t, err, "failed to insert recipe at index %d", i)
 		require.NotEmptyf(t, id, "failed to create an ID at index %d", i)
 	}
 
-	require.NoError(t, testutils.AssertCountByType[types.Recipe](len(testRecipes), GetCountByType), "failed to get the count")
+	require.NoError(t, testutils.AssertCountByType[types.Recipe](store, len(testRecipes), GetCountByType[types.Recipe]), "failed to get the count")
 
 	for i, recipe := range testRecipes {
-		_, err := DeleteByType[types.Recipe](testRecipes[i].ID)
+		_, err := DeleteByType[types.Recipe](store, testRecipes[i].ID)
 		require.NoErrorf(t, err, "failed to delete recipe at index %d (ID: %s)", i, recipe.GetID())
 	}
 
-	require.NoError(t, testutils.AssertCountByType[types.Recipe](0, GetCountByType), "failed to get the count after deletions")
+	require.NoError(t, testutils.AssertCountByType[types.Recipe](store, 0, GetCountByType[types.Recipe]), "failed to get the count after deletions")
 
 }
 
 func TestOneToMany(t *testing.T) {
-	ingredientIDs, err := CreateManyByType(testIngredients)
+	store := newTestStore(t)
+	ingredientIDs, err := CreateManyByType(store, testIngredients)
 	require.NoError(t, err, "error creating ingredients")
 	for i := range ingredientIDs {
 		testIngredients[i].ID = ingredientIDs[i]
 	}
 
-	recipeIDs, err := CreateManyByType(testRecipes)
+	recipeIDs, err := CreateManyByType(store, testRecipes)
 	require.NoError(t, err, "error creating recipes")
 	for i := range recipeIDs {
 		testRecipes[i].ID = recipeIDs[i]
@@ -124,62 +134,301 @@ func TestOneToMany(t *testing.T) {
 			testRecipes[i],
 			types.IngredientToRecipeIngredient,
 		)
-		err = CreateManyToManyByType(testRecipes[i].ID, recipeIngredients)
+		err = CreateManyToManyByType(store, testRecipes[i].ID, recipeIngredients)
 		require.NoErrorf(t, err, "failed to insert relations at index %d (Recipe ID: %s)", i, testRecipes[i].ID)
 	}
 
 	tableName := types.RecipeIngredient{}.TableName()
 	expectedLength := len(testIngredients) * len(testRecipes)
-	require.NoError(t, testutils.AssertCountByTable(expectedLength, tableName, GetCountByTable), "failed to get the count")
-	recipeIngrdient, err := GetRelationByType[types.RecipeIngredient](recipeIDs[0], ingredientIDs[0])
+	require.NoError(t, testutils.AssertCountByTable(store, expectedLength, tableName, GetCountByTable), "failed to get the count")
+	recipeIngrdient, err := GetRelationByType[types.RecipeIngredient](store, recipeIDs[0], ingredientIDs[0])
 	require.NoError(t, err, "error getting recipe ingredient")
 	require.Greater(t, len(recipeIngrdient.Amount), 1, "expecting amount to contain a string")
-	require.NoError(t, DeleteManyByType[types.Recipe](recipeIDs), "error deleting recipes")
-	require.NoError(t, testutils.AssertCountByTable(0, tableName, GetCountByTable), "failed to get the count after deleting recipes")
-	require.NoError(t, DeleteManyByType[types.Ingredient](ingredientIDs), "error deleting ingredients")
+	require.NoError(t, DeleteManyByType[types.Recipe](store, recipeIDs), "error deleting recipes")
+	require.NoError(t, testutils.AssertCountByTable(store, expectedLength, tableName, GetCountByTable), "trashed recipes keep their ingredients")
+	_, err = PurgeByType[types.Recipe](store, 0)
+	require.NoError(t, err, "error purging recipes")
+	require.NoError(t, testutils.AssertCountByTable(store, 0, tableName, GetCountByTable), "failed to get the count after deleting recipes")
+	require.NoError(t, DeleteManyByType[types.Ingredient](store, ingredientIDs), "error deleting ingredients")
 }
 
 func TestCreateByTypeWithRelations(t *testing.T) {
-	ids, err := CreateManyByType(testIngredients)
+	store := newTestStore(t)
+	ids, err := CreateManyByType(store, testIngredients)
 	require.NoError(t, err, "error creating ingredients")
 
 	for i := range ids {
 		testIngredients[i].ID = ids[i]
 	}
 
-	recipeIngredients := types.ToOneToMany(
+	recipe := testRecipe
+	recipe.RecipeIngredients = types.ToOneToMany(
 		testIngredients,
-		testRecipe,
+		recipe,
 		types.IngredientToRecipeIngredient,
 	)
 
-	testRecipe.RecipeIngredients = recipeIngredients
-	id, err := CreateByTypeWithRelations(testRecipe)
+	id, err := CreateByTypeWithRelations(store, recipe)
 	tableName := types.RecipeIngredient{}.TableName()
 
 	require.NoError(t, err, "error creating recipe with relations")
 	require.NotEmpty(t, id, "error generating a ID")
 
-	testRecipe.ID = id
-
 	expectedLength := len(testIngredients)
-	require.NoError(t, testutils.AssertCountByTable(expectedLength, tableName, GetCountByTable), "failed to get the count")
+	require.NoError(t, testutils.AssertCountByTable(store, expectedLength, tableName, GetCountByTable), "failed to get the count")
 
-	_, err = DeleteByType[types.Recipe](id)
+	_, err = DeleteByType[types.Recipe](store, id)
 	require.NoError(t, err, "error deleting recipes")
 
-	require.NoError(t, testutils.AssertCountByType[types.Recipe](0, GetCountByType))
-	require.NoError(t, testutils.AssertCountByTable(0, tableName, GetCountByTable), "failed to get the count after deletions")
+	require.NoError(t, testutils.AssertCountByType[types.Recipe](store, 0, GetCountByType[types.Recipe]))
+	_, err = PurgeByType[types.Recipe](store, 0)
+	require.NoError(t, err, "error purging recipes")
+	require.NoError(t, testutils.AssertCountByTable(store, 0, tableName, GetCountByTable), "failed to get the count after deletions")
 }
 
 func TestGetAll(t *testing.T) {
-	ids, err := CreateManyByType(testRecipes)
+	store := newTestStore(t)
+	ids, err := CreateManyByType(store, testRecipes)
 	require.NoError(t, err, "error creating ingredients")
 
-	newRecipes, err := GetAllByType[types.Recipe]()
+	newRecipes, err := GetAllByType[types.Recipe](store)
 	require.NoError(t, err, "error getting ingredients")
 	assert.Equal(t, len(newRecipes), len(testRecipes))
 
-	err = DeleteManyByType[types.Recipe](ids)
+	err = DeleteManyByType[types.Recipe](store, ids)
 	require.NoError(t, err, "error deleting ingredients")
 }
+
+func TestCreateByTypeWithRelationsRollback(t *testing.T) {
+	store := newTestStore(t)
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeIngredients = []types.RecipeIngredient{
+		{IngredientId: "does-not-exist", Amount: "1 stk"},
+	}
+	recipe.RecipeSteps = testSteps[:2]
+
+	id, err := CreateByTypeWithRelations(store, recipe)
+	require.Error(t, err, "expected foreign key violation")
+	require.Empty(t, id)
+
+	require.NoError(t, testutils.AssertCountByType[types.Recipe](store, 0, GetCountByType[types.Recipe]), "recipe should be rolled back")
+	require.NoError(t, testutils.AssertCountByTable(store, 0, types.RecipeIngredient{}.TableName(), GetCountByTable))
+	require.NoError(t, testutils.AssertCountByTable(store, 0, types.RecipeStep{}.TableName(), GetCountByTable))
+}
+
+func TestCreateByTypeWithSteps(t *testing.T) {
+	store := newTestStore(t)
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeSteps = testSteps
+
+	id, err := CreateByTypeWithRelations(store, recipe)
+	require.NoError(t, err, "error creating recipe with steps")
+
+	tableName := types.RecipeStep{}.TableName()
+	require.NoError(t, testutils.AssertCountByTable(store, len(testSteps), tableName, GetCountByTable))
+
+	_, err = DeleteByType[types.Recipe](store, id)
+	require.NoError(t, err, "error deleting recipe")
+	_, err = PurgeByType[types.Recipe](store, 0)
+	require.NoError(t, err, "error purging recipe")
+	require.NoError(t, testutils.AssertCountByTable(store, 0, tableName, GetCountByTable))
+}
+
+func TestGetByTypeWithRelations(t *testing.T) {
+	store := newTestStore(t)
+	ingredients := ingredientGenerator.GenerateMany(3)
+	ingredientIDs, err := CreateManyByType(store, ingredients)
+	require.NoError(t, err, "error creating ingredients")
+	defer func() {
+		require.NoError(t, DeleteManyByType[types.Ingredient](store, ingredientIDs), "error deleting ingredients")
+	}()
+	for i := range ingredientIDs {
+		ingredients[i].ID = ingredientIDs[i]
+	}
+
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeIngredients = types.ToOneToMany(ingredients, recipe, types.IngredientToRecipeIngredient)
+	recipe.RecipeSteps = testSteps[:4]
+
+	id, err := CreateByTypeWithRelations(store, recipe)
+	require.NoError(t, err, "error creating recipe with relations")
+	defer func() {
+		_, err := DeleteByType[types.Recipe](store, id)
+		require.NoError(t, err, "error deleting recipe")
+	}()
+
+	got, err := GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: RelationNames[types.Recipe]()})
+	require.NoError(t, err, "error getting recipe with relations")
+	require.Len(t, got.RecipeIngredients, len(ingredients))
+	require.Len(t, got.RecipeSteps, 4)
+
+	for i, ri := range got.RecipeIngredients {
+		assert.Equal(t, id, ri.RecipeId)
+		assert.Equal(t, ingredients[i].Name, ri.Name, "expected joined ingredient name")
+	}
+	for i, step := range got.RecipeSteps {
+		assert.Equal(t, id, step.RecipeID)
+		assert.Equal(t, testSteps[i].Step, step.Step, "expected steps in insertion order")
+	}
+
+	got, err = GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: []string{"steps"}})
+	require.NoError(t, err)
+	assert.Empty(t, got.RecipeIngredients)
+	assert.Len(t, got.RecipeSteps, 4)
+
+	many, err := GetManyByType[types.Recipe](store, QueryOptions{Page: 1, PerPage: 10, Include: []string{"ingredients"}})
+	require.NoError(t, err)
+	require.Len(t, many, 1)
+	assert.Len(t, many[0].RecipeIngredients, len(ingredients))
+	assert.NotNil(t, many[0].RecipeIngredients)
+
+	_, err = GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: []string{"comments"}})
+	require.ErrorIs(t, err, ErrUnknownRelation)
+}
+
+func TestUpdateByTypeWithRelations(t *testing.T) {
+	store := newTestStore(t)
+	ingredients := ingredientGenerator.GenerateMany(4)
+	ingredientIDs, err := CreateManyByType(store, ingredients)
+	require.NoError(t, err, "error creating ingredients")
+	defer func() {
+		require.NoError(t, DeleteManyByType[types.Ingredient](store, ingredientIDs), "error deleting ingredients")
+	}()
+	for i := range ingredientIDs {
+		ingredients[i].ID = ingredientIDs[i]
+	}
+
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeIngredients = types.ToOneToMany(ingredients[:3], recipe, types.IngredientToRecipeIngredient)
+	recipe.RecipeSteps = testSteps[:3]
+
+	id, err := CreateByTypeWithRelations(store, recipe)
+	require.NoError(t, err, "error creating recipe with relations")
+	defer func() {
+		_, err := DeleteByType[types.Recipe](store, id)
+		require.NoError(t, err, "error deleting recipe")
+	}()
+
+	stored, err := GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: RelationNames[types.Recipe]()})
+	require.NoError(t, err)
+
+	update := stored
+	update.Name = "Updated with relations"
+	update.RecipeIngredients = []types.RecipeIngredient{
+		{IngredientId: ingredients[0].ID, Amount: "2 dl"},
+		stored.RecipeIngredients[1],
+		{IngredientId: ingredients[3].ID, Amount: "1 knsp"},
+	}
+	changedStep := stored.RecipeSteps[0]
+	changedStep.Step = "changed step"
+	update.RecipeSteps = []types.RecipeStep{
+		changedStep,
+		stored.RecipeSteps[2],
+		{Step: "new step"},
+	}
+
+	_, err = UpdateByTypeWithRelations(store, update)
+	require.NoError(t, err, "error updating recipe with relations")
+
+	got, err := GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: RelationNames[types.Recipe]()})
+	require.NoError(t, err)
+	assert.Equal(t, "Updated with relations", got.Name)
+
+	amounts := map[string]string{}
+	for _, ri := range got.RecipeIngredients {
+		amounts[ri.IngredientId] = ri.Amount
+	}
+	assert.Equal(t, map[string]string{
+		ingredients[0].ID: "2 dl",
+		ingredients[1].ID: stored.RecipeIngredients[1].Amount,
+		ingredients[3].ID: "1 knsp",
+	}, amounts)
+
+	require.Len(t, got.RecipeSteps, 3)
+	assert.Equal(t, changedStep.ID, got.RecipeSteps[0].ID)
+	assert.Equal(t, "changed step", got.RecipeSteps[0].Step)
+	kept := stored.RecipeSteps[2]
+	kept.Position = 1
+	assert.Equal(t, kept, got.RecipeSteps[1])
+	assert.Equal(t, "new step", got.RecipeSteps[2].Step)
+
+	update = got
+	update.RecipeIngredients = append(update.RecipeIngredients, types.RecipeIngredient{IngredientId: "does-not-exist", Amount: "1"})
+	update.RecipeSteps = []types.RecipeStep{}
+	_, err = UpdateByTypeWithRelations(store, update)
+	require.Error(t, err, "expected foreign key violation")
+
+	after, err := GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: RelationNames[types.Recipe]()})
+	require.NoError(t, err)
+	assert.Len(t, after.RecipeIngredients, 3, "ingredients should be rolled back")
+	assert.Len(t, after.RecipeSteps, 3, "steps should be rolled back")
+
+	update = after
+	update.RecipeIngredients = nil
+	update.RecipeSteps = []types.RecipeStep{}
+	_, err = UpdateByTypeWithRelations(store, update)
+	require.NoError(t, err)
+
+	after, err = GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: RelationNames[types.Recipe]()})
+	require.NoError(t, err)
+	assert.Len(t, after.RecipeIngredients, 3, "nil relation should be left untouched")
+	assert.Empty(t, after.RecipeSteps, "empty relation should remove all steps")
+
+	update.ID = "does-not-exist"
+	_, err = UpdateByTypeWithRelations(store, update)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestUpdateReordersRelations(t *testing.T) {
+	store := newTestStore(t)
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeSteps = []types.RecipeStep{{Step: "first"}, {Step: "second"}, {Step: "third"}}
+	recipe.RecipeIngredients = []types.RecipeIngredient{{IngredientId: "5", Amount: "1"}, {IngredientId: "124", Amount: "2"}}
+	id, err := CreateByTypeWithRelations(store, recipe)
+	require.NoError(t, err)
+
+	stored, err := GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: RelationNames[types.Recipe]()})
+	require.NoError(t, err)
+	steps := stored.RecipeSteps
+	stored.RecipeSteps = []types.RecipeStep{steps[2], {Step: "new"}, steps[0]}
+	stored.RecipeIngredients = []types.RecipeIngredient{stored.RecipeIngredients[1], stored.RecipeIngredients[0]}
+	_, err = UpdateByTypeWithRelations(store, stored)
+	require.NoError(t, err)
+
+	got, err := GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: RelationNames[types.Recipe]()})
+	require.NoError(t, err)
+	var order []string
+	for _, step := range got.RecipeSteps {
+		order = append(order, step.Step)
+	}
+	assert.Equal(t, []string{"third", "new", "first"}, order, "steps are read back in the order they were sent")
+	assert.Equal(t, steps[2].ID, got.RecipeSteps[0].ID, "moved steps keep their id")
+	require.Len(t, got.RecipeIngredients, 2)
+	assert.Equal(t, "124", got.RecipeIngredients[0].IngredientId)
+	assert.Equal(t, "5", got.RecipeIngredients[1].IngredientId)
+}
+
+func TestStoresAreIsolated(t *testing.T) {
+	first := newTestStore(t)
+	second := newTestStore(t)
+
+	_, err := CreateByType(first, recipeGenerator.Generate())
+	require.NoError(t, err)
+
+	require.NoError(t, testutils.AssertCountByType[types.Recipe](first, 1, GetCountByType[types.Recipe]))
+	require.NoError(t, testutils.AssertCountByType[types.Recipe](second, 0, GetCountByType[types.Recipe]))
+}
+
+func TestCRUDContextCancelled(t *testing.T) {
+	store := newTestStore(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := GetManyByTypeContext[types.Recipe](ctx, store, QueryOptions{Page: 1, PerPage: 10})
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = CreateByTypeWithRelationsContext(ctx, store, recipeGenerator.Generate())
+	require.ErrorIs(t, err, context.Canceled)
+	require.NoError(t, testutils.AssertCountByType[types.Recipe](store, 0, GetCountByType[types.Recipe]))
+}
@@ -1,13 +1,15 @@
 package api
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"opskrifter-backend/internal/types"
-	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -17,12 +19,20 @@ type Response struct {
 	Message string `json:"message"`
 }
 
+// StatusClientClosedRequest is answered when the client went away before the
+// operation finished. Nobody reads it, but writeError logs it.
+const StatusClientClosedRequest = 499
+
 type (
-	DeleteFunc[T types.Identifiable]  func(id string) (string, error)
-	CrudFunc[T types.Identifiable]    func(T) (string, error)
-	GetFunc[T types.Identifiable]     func(id string) (T, error)
-	GetManyFunc[T types.Identifiable] func(q QueryOptions) ([]T, error)
-	GetAllFunc[T types.Identifiable]  func() ([]T, error)
+	DeleteFunc[T types.Identifiable]         func(ctx context.Context, id string) (string, error)
+	CrudFunc[T types.Identifiable]           func(ctx context.Context, obj T) (string, error)
+	GetFunc[T types.Identifiable]            func(ctx context.Context, id string) (T, error)
+	GetWithOptionsFunc[T types.Identifiable] func(ctx context.Context, id string, opts QueryOptions) (T, error)
+	GetManyFunc[T types.Identifiable]        func(ctx context.Context, q QueryOptions) ([]T, error)
+	CountFunc[T types.Identifiable]          func(ctx context.Context, q QueryOptions) (int, error)
+	GetAllFunc[T types.Identifiable]         func(ctx context.Context, opts QueryOptions) ([]T, error)
+	PatchFunc[T types.Identifiable]          func(ctx context.Context, id string, patch Patch) (string, error)
+	BatchFunc[T types.Identifiable]          func(ctx context.Context, mode BatchMode, ops []BatchOperation[T]) ([]BatchItem, error)
 )
 
 func HandlerByType[T types.Identifiable](crudFunc CrudFunc[T]) http.HandlerFunc {
@@ -30,13 +40,19 @@ func HandlerByType[T types.Identifiable](crudFunc CrudFunc[T]) http.HandlerFunc
 		var obj T
 
 		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
-			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			writeProblem(w, r, kindInvalidJSON, "invalid JSON: "+err.Error())
 			return
 		}
 
-		id, err := crudFunc(obj)
+		ctx, err := ifMatchContext(r)
 		if err != nil {
-			http.Error(w, "operation failed: "+err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
+			return
+		}
+
+		id, err := crudFunc(createMissingContext(ctx, r), obj)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
@@ -53,23 +69,81 @@ func HandlerByType[T types.Identifiable](crudFunc CrudFunc[T]) http.HandlerFunc
 	}
 }
 
-func DeleteHandlerByType[T types.Identifiable](deleteFunc DeleteFunc[T]) http.HandlerFunc {
+// PatchHandlerByType accepts a JSON Merge Patch or a JSON Patch depending on
+// the request Content-Type.
+func PatchHandlerByType[T types.Identifiable](patchFunc PatchFunc[T]) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 		if id == "" {
-			http.Error(w, "missing id", http.StatusBadRequest)
+			writeProblem(w, r, kindInvalidRequest, "missing id")
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+		var patch Patch
+		switch mediaType {
+		case MergePatchContentType:
+			var mergePatch MergePatch
+			if err := json.NewDecoder(r.Body).Decode(&mergePatch); err != nil {
+				writeProblem(w, r, kindInvalidJSON, "invalid JSON: "+err.Error())
+				return
+			}
+			patch = mergePatch
+		case JSONPatchContentType:
+			var jsonPatch JSONPatch
+			if err := json.NewDecoder(r.Body).Decode(&jsonPatch); err != nil {
+				writeProblem(w, r, kindInvalidJSON, "invalid JSON: "+err.Error())
+				return
+			}
+			patch = jsonPatch
+		default:
+			w.Header().Set("Accept-Patch", MergePatchContentType+", "+JSONPatchContentType)
+			writeProblem(w, r, kindUnsupportedPatch, "unsupported patch format")
+			return
+		}
+
+		ctx, err := ifMatchContext(r)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
-		_, err := deleteFunc(id)
+		_, err = patchFunc(ctx, id, patch)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		resp := Response{
+			ID:      id,
+			Message: "operation succeeded",
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("failed to encode response: %v", err)
+		}
+	}
+}
+
+func DeleteHandlerByType[T types.Identifiable](deleteFunc DeleteFunc[T]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if id == "" {
+			writeProblem(w, r, kindInvalidRequest, "missing id")
+			return
+		}
 
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
+		ctx, err := ifMatchContext(r)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
+		_, err = deleteFunc(ctx, id)
 		if err != nil {
-			http.Error(w, "operation failed: "+err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
@@ -85,75 +159,277 @@ func DeleteHandlerByType[T types.Identifiable](deleteFunc DeleteFunc[T]) http.Ha
 	}
 }
 
+type BatchRequest[T types.Identifiable] struct {
+	Mode       BatchMode           `json:"mode"`
+	Operations []BatchOperation[T] `json:"operations"`
+}
+
+// BatchResult is the status the operation at Index would have been answered
+// with on its own. A failed operation carries the type and detail of that
+// problem.
+type BatchResult struct {
+	Index  int          `json:"index"`
+	ID     string       `json:"id,omitempty"`
+	Status int          `json:"status"`
+	Type   string       `json:"type,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// BatchResponse counts the operations that were not attempted apart from the
+// ones that failed or were rolled back.
+type BatchResponse struct {
+	Mode         BatchMode     `json:"mode"`
+	Succeeded    int           `json:"succeeded"`
+	Failed       int           `json:"failed"`
+	NotAttempted int           `json:"not_attempted"`
+	Results      []BatchResult `json:"results"`
+}
+
+// BatchHandlerByType answers 200 when every operation succeeded and 207 with
+// the per operation results otherwise.
+func BatchHandlerByType[T types.Identifiable](batchFunc BatchFunc[T]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BatchRequest[T]
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, kindInvalidJSON, "invalid JSON: "+err.Error())
+			return
+		}
+
+		items, err := batchFunc(createMissingContext(r.Context(), r), req.Mode, req.Operations)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		resp := BatchResponse{
+			Mode:    req.Mode,
+			Results: make([]BatchResult, len(items)),
+		}
+		if resp.Mode == "" {
+			resp.Mode = BatchAtomic
+		}
+
+		for i, item := range items {
+			result := BatchResult{Index: i, ID: item.ID, Status: http.StatusOK}
+			if req.Operations[i].Op == BatchAdd {
+				result.Status = http.StatusCreated
+			}
+
+			if item.Err != nil {
+				p := problemOf(item.Err)
+				result.Status, result.Type, result.Error = p.Status, p.Type, p.Detail
+				result.Errors, _ = p.Errors.([]FieldError)
+				if errors.Is(item.Err, ErrBatchNotAttempted) {
+					resp.NotAttempted++
+				} else {
+					resp.Failed++
+				}
+			} else {
+				resp.Succeeded++
+			}
+			resp.Results[i] = result
+		}
+
+		status := http.StatusOK
+		if resp.Failed+resp.NotAttempted > 0 {
+			status = http.StatusMultiStatus
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("failed to encode response: %v", err)
+		}
+	}
+}
+
 func GetHandlerByType[T types.Identifiable](getFunc GetFunc[T]) http.HandlerFunc {
+	return GetHandlerWithOptionsByType(func(ctx context.Context, id string, _ QueryOptions) (T, error) {
+		return getFunc(ctx, id)
+	})
+}
+
+// GetHandlerWithOptionsByType loads every relation of T unless the request
+// narrows it down with ?include= or asks for a sparse fieldset with ?fields=.
+func GetHandlerWithOptionsByType[T types.Identifiable](getFunc GetWithOptionsFunc[T]) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 		if id == "" {
-			http.Error(w, "missing id", http.StatusBadRequest)
+			writeProblem(w, r, kindInvalidRequest, "missing id")
 			return
 		}
 
-		result, err := getFunc(id)
+		query := r.URL.Query()
+		opts := QueryOptions{Fields: parseFields(query.Get("fields"))}
+		if _, ok := query["include"]; ok {
+			opts.Include = parseInclude(query)
+		} else if len(opts.Fields) == 0 {
+			opts.Include = RelationNames[T]()
+		}
 
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
+		result, err := getFunc(r.Context(), id, opts)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
+		var payload any = result
+		if len(opts.Fields) > 0 {
+			projected, err := projectFields([]T{result}, opts.Fields, opts.Include)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			payload = projected[0]
+		}
+
+		body, err := json.Marshal(payload)
 		if err != nil {
-			http.Error(w, "operation failed: "+err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
+		body = append(body, '\n')
+
+		if version, ok := versionOf(result); ok {
+			etag := ETag(version, body)
+			w.Header().Set("ETag", etag)
+			if noneMatch(r.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(result)
+		w.Write(body)
 	}
 }
 
-func GetHandlerManyByType[T types.Identifiable](getManyFunc GetManyFunc[T]) http.HandlerFunc {
+// GetHandlerManyByType returns a page of T wrapped in a ListResponse, with
+// the total taken from countFunc and Link headers to the neighbouring pages.
+func GetHandlerManyByType[T types.Identifiable](getManyFunc GetManyFunc[T], countFunc CountFunc[T]) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		query := r.URL.Query()
-		page, err := strconv.ParseInt(query.Get("page"), 32, 32)
+		_, useCursor := query["cursor"]
+
+		page, perPage, err := parsePagination(query)
 		if err != nil {
-			http.Error(w, "err parsing page: "+err.Error(), http.StatusBadRequest)
+			writeProblem(w, r, kindInvalidQueryOption, err.Error())
 			return
 		}
 
-		perPage, err := strconv.ParseInt(query.Get("per_page"), 32, 32)
+		filters, err := ParseFilters(query)
 		if err != nil {
-			http.Error(w, "err parsing page: "+err.Error(), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
 		}
 
 		ops := QueryOptions{
-			Page:    int(page),
-			PerPage: int(perPage),
-			OrderBy: query.Get("order_by"),
+			Page:      page,
+			PerPage:   perPage,
+			OrderBy:   query.Get("order_by"),
+			Include:   parseInclude(query),
+			Fields:    parseFields(query.Get("fields")),
+			Filters:   filters,
+			UseCursor: useCursor,
+			Cursor:    query.Get("cursor"),
+
+			ExcludeIngredients: parseIDs(query.Get("exclude_ingredients")),
+			RequireIngredients: parseIDs(query.Get("require_ingredients")),
+		}
+		if useCursor {
+			// One row past the page tells whether there is a next one.
+			ops.PerPage++
 		}
 
-		result, err := getManyFunc(ops)
+		result, err := getManyFunc(r.Context(), ops)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-		if err == ErrNotValidOrderBy {
-			http.Error(w, "operation failed "+err.Error(), http.StatusBadRequest)
+		more := useCursor && len(result) > perPage
+		if more {
+			result = result[:perPage]
 		}
+
+		total, err := countFunc(r.Context(), ops)
 		if err != nil {
-			http.Error(w, "operation failed: "+err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
+			return
+		}
+
+		resp := ListResponse[T]{
+			Items:   result,
+			PerPage: perPage,
+			Total:   total,
+		}
+		if resp.Items == nil {
+			resp.Items = []T{}
+		}
+
+		if useCursor {
+			if more {
+				resp.NextCursor, err = EncodeCursor(result[len(result)-1], ops.OrderBy)
+				if err != nil {
+					writeError(w, r, err)
+					return
+				}
+			}
+			resp.HasMore = resp.NextCursor != ""
+		} else {
+			resp.Page = page
+			resp.HasMore = page*perPage < total
+		}
+
+		setLinkHeader(w, r, resp)
+
+		if len(ops.Fields) > 0 {
+			projected, err := projectFields(resp.Items, ops.Fields, ops.Include)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(ListResponse[map[string]any]{
+				Items:      projected,
+				Page:       resp.Page,
+				PerPage:    resp.PerPage,
+				Total:      resp.Total,
+				HasMore:    resp.HasMore,
+				NextCursor: resp.NextCursor,
+			})
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(result)
+		json.NewEncoder(w).Encode(resp)
 	}
 }
 
 func GetAllHandlerManyByType[T types.Identifiable](getAllFunc GetAllFunc[T]) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		result, err := getAllFunc()
-
+		opts := QueryOptions{Fields: parseFields(r.URL.Query().Get("fields"))}
+		result, err := getAllFunc(r.Context(), opts)
 		if err != nil {
-			http.Error(w, "operation failed: "+err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
+			return
+		}
+
+		if len(opts.Fields) > 0 {
+			projected, err := projectFields(result, opts.Fields, nil)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(projected)
 			return
 		}
 
@@ -162,3 +438,14 @@ func GetAllHandlerManyByType[T types.Identifiable](getAllFunc GetAllFunc[T]) htt
 		json.NewEncoder(w).Encode(result)
 	}
 }
+
+func parseInclude(query url.Values) []string {
+	var include []string
+	for _, name := range strings.Split(query.Get("include"), ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			include = append(include, name)
+		}
+	}
+	return include
+}
@@ -1,25 +1,31 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"opskrifter-backend/internal/testutils"
 	"opskrifter-backend/internal/types"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/require"
 )
 
 func TestCreateHandlerByType(t *testing.T) {
+	store := newTestStore(t)
+	resource := newRecipeResource(store)
 	data, err := json.Marshal(testRecipe)
 	require.NoError(t, err, "failed to marshal testRecipe")
 
 	req, rec := testutils.NewJSONPostRequest(data)
-	CreateRecipe.ServeHTTP(rec, req)
+	resource.Create.ServeHTTP(rec, req)
 
 	resp := rec.Result()
 	defer resp.Body.Close()
@@ -32,16 +38,18 @@ func TestCreateHandlerByType(t *testing.T) {
 	var response Response
 	require.NoError(t, json.Unmarshal(body, &response), "failed to unmarshal response JSON")
 	require.NotEmpty(t, response.ID, "expected non-empty id field in response")
-	require.NoError(t, testutils.AssertCountByType[types.Recipe](1, GetCountByType))
+	require.NoError(t, testutils.AssertCountByType[types.Recipe](store, 1, GetCountByType[types.Recipe]))
 
 	id := response.ID
-	_, err = DeleteByType[types.Recipe](id)
+	_, err = DeleteByType[types.Recipe](store, id)
 	require.NoError(t, err, "failed to delete recipe")
-	require.NoError(t, testutils.AssertCountByType[types.Recipe](0, GetCountByType))
+	require.NoError(t, testutils.AssertCountByType[types.Recipe](store, 0, GetCountByType[types.Recipe]))
 }
 
 func TestDeleteHandlerByType(t *testing.T) {
-	id, err := CreateByType(handlerRecipe)
+	store := newTestStore(t)
+	resource := newRecipeResource(store)
+	id, err := CreateByType(store, handlerRecipe)
 	require.NoError(t, err, "failed to create recipe")
 	require.NotEmpty(t, id, "failed to generate id")
 	req := httptest.NewRequest(http.MethodDelete, "/recipes/"+id, nil)
@@ -50,7 +58,7 @@ func TestDeleteHandlerByType(t *testing.T) {
 	chiCtx.URLParams.Add("id", id)
 	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
 
-	DeleteRecipe.ServeHTTP(rec, req)
+	resource.Delete.ServeHTTP(rec, req)
 
 	resp := rec.Result()
 	defer resp.Body.Close()
@@ -63,11 +71,13 @@ func TestDeleteHandlerByType(t *testing.T) {
 	var response Response
 	require.NoError(t, json.Unmarshal(body, &response), "failed to unmarshal response JSON")
 	require.NotEmpty(t, response.ID, "expected non-empty id field in response")
-	require.NoError(t, testutils.AssertCountByType[types.Recipe](0, GetCountByType))
+	require.NoError(t, testutils.AssertCountByType[types.Recipe](store, 0, GetCountByType[types.Recipe]))
 }
 
 func TestUpdateHandlerByType(t *testing.T) {
-	id, err := CreateByType(handlerRecipe)
+	store := newTestStore(t)
+	resource := newRecipeResource(store)
+	id, err := CreateByType(store, handlerRecipe)
 	require.NoError(t, err, "failed to create recipe")
 	require.NotEmpty(t, id, "failed to generate id")
 
@@ -78,7 +88,7 @@ func TestUpdateHandlerByType(t *testing.T) {
 	require.NoError(t, err, "failed to marshal updatedRecipe")
 
 	req, rec := testutils.NewJSONPostRequest(data)
-	UpdateRecipe.ServeHTTP(rec, req)
+	resource.Update.ServeHTTP(rec, req)
 
 	resp := rec.Result()
 	defer resp.Body.Close()
@@ -93,50 +103,52 @@ func TestUpdateHandlerByType(t *testing.T) {
 
 	require.NotEmpty(t, response.ID, "expected non-empty id field in response")
 
-	require.NoError(t, testutils.AssertCountByType[types.Recipe](1, GetCountByType), "failed to get the count")
+	require.NoError(t, testutils.AssertCountByType[types.Recipe](store, 1, GetCountByType[types.Recipe]), "failed to get the count")
 
-	updated, err := GetByType[types.Recipe](updatedRecipe.ID)
+	updated, err := GetByType[types.Recipe](store, updatedRecipe.ID)
 	require.NoError(t, err, "error fetching updated recipe")
 
 	testutils.EqualByValue(updatedRecipe, updated)
 
-	_, err = DeleteByType[types.Recipe](id)
+	_, err = DeleteByType[types.Recipe](store, id)
 	require.NoError(t, err, "error deleting recipe")
 
 }
 
 func TestGetManyHandlerByType(t *testing.T) {
-	ids, err := CreateManyByType(testRecipes)
+	store := newTestStore(t)
+	resource := newRecipeResource(store)
+	ids, err := CreateManyByType(store, testRecipes)
 	require.NoError(t, err, "error creating recipes")
 
 	defer func() {
-		err = DeleteManyByType[types.Recipe](ids)
+		err = DeleteManyByType[types.Recipe](store, ids)
 		require.NoError(t, err, "error deleting recipes")
 	}()
 
 	req := httptest.NewRequest("GET", "/?page=0&per_page=5&order_by=name", nil)
 	rec := httptest.NewRecorder()
 
-	GetManyRecipe.ServeHTTP(rec, req)
+	resource.List.ServeHTTP(rec, req)
 
 	res := rec.Result()
 	defer res.Body.Close()
 
 	require.Equal(t, http.StatusOK, res.StatusCode, "expected status OK")
 
-	var got []types.Recipe
+	var got ListResponse[types.Recipe]
 	err = json.NewDecoder(res.Body).Decode(&got)
 	require.NoError(t, err, "error decoding response")
 
-	require.Len(t, got, 5, "expected 5 recipes in result")
-	testutils.AssertSortedBy(t, got, func(a, b types.Recipe) bool {
+	require.Len(t, got.Items, 5, "expected 5 recipes in result")
+	testutils.AssertSortedBy(t, got.Items, func(a, b types.Recipe) bool {
 		return a.Name <= b.Name
 	})
 
 	req = httptest.NewRequest("GET", "/?page=0&per_page=5&order_by=loool", nil)
 	rec = httptest.NewRecorder()
 
-	GetManyRecipe.ServeHTTP(rec, req)
+	resource.List.ServeHTTP(rec, req)
 
 	res = rec.Result()
 	defer res.Body.Close()
@@ -146,15 +158,103 @@ func TestGetManyHandlerByType(t *testing.T) {
 	req = httptest.NewRequest("GET", "/?page=2&per_page=5", nil)
 	rec = httptest.NewRecorder()
 
-	GetManyRecipe.ServeHTTP(rec, req)
+	resource.List.ServeHTTP(rec, req)
 
 	res = rec.Result()
 	defer res.Body.Close()
 
 	require.Equal(t, http.StatusOK, res.StatusCode, "expected status OK")
+	got = ListResponse[types.Recipe]{}
 	err = json.NewDecoder(res.Body).Decode(&got)
 	require.NoError(t, err, "error decoding response")
 
-	require.Len(t, got, 5, "expected 5 recipes in result")
-	require.Equal(t, ids[5], got[0].ID)
+	require.Len(t, got.Items, 5, "expected 5 recipes in result")
+	require.Equal(t, ids[5], got.Items[0].ID)
+	require.Equal(t, 2, got.Page)
+	require.Equal(t, len(ids), got.Total)
+	require.False(t, got.HasMore)
+}
+
+func TestGetManyHandlerByTypeEnvelope(t *testing.T) {
+	store := newTestStore(t)
+	resource := newRecipeResource(store)
+	recipes := recipeGenerator.GenerateMany(25)
+	ids, err := CreateManyByType(store, recipes)
+	require.NoError(t, err, "error creating recipes")
+
+	defer func() {
+		require.NoError(t, DeleteManyByType[types.Recipe](store, ids), "error deleting recipes")
+	}()
+
+	req := httptest.NewRequest("GET", "/recipes/?page=2&per_page=10&order_by=name", nil)
+	rec := httptest.NewRecorder()
+	resource.List.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var got ListResponse[types.Recipe]
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	require.Len(t, got.Items, 10)
+	require.Equal(t, 2, got.Page)
+	require.Equal(t, 10, got.PerPage)
+	require.Equal(t, 25, got.Total)
+	require.True(t, got.HasMore)
+
+	link := rec.Header().Get("Link")
+	require.Contains(t, link, `</recipes/?order_by=name&page=1&per_page=10>; rel="first"`)
+	require.Contains(t, link, `</recipes/?order_by=name&page=1&per_page=10>; rel="prev"`)
+	require.Contains(t, link, `</recipes/?order_by=name&page=3&per_page=10>; rel="next"`)
+	require.Contains(t, link, `</recipes/?order_by=name&page=3&per_page=10>; rel="last"`)
+
+	req = httptest.NewRequest("GET", "/recipes/", nil)
+	rec = httptest.NewRecorder()
+	resource.List.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, "missing page and per_page should use defaults")
+
+	got = ListResponse[types.Recipe]{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	require.Equal(t, 1, got.Page)
+	require.Equal(t, DefaultPerPage, got.PerPage)
+	require.Len(t, got.Items, DefaultPerPage)
+	require.NotContains(t, rec.Header().Get("Link"), `rel="prev"`)
+
+	req = httptest.NewRequest("GET", "/recipes/?per_page=100000", nil)
+	rec = httptest.NewRecorder()
+	resource.List.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	got = ListResponse[types.Recipe]{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	require.Equal(t, MaxPerPage, got.PerPage)
+	require.False(t, got.HasMore)
+
+	req = httptest.NewRequest("GET", "/recipes/?page=abc", nil)
+	rec = httptest.NewRecorder()
+	resource.List.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestHandlerContextErrors(t *testing.T) {
+	_, router := newTestEnv(t)
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req := httptest.NewRequest("GET", "/recipes/", nil).WithContext(cancelled)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, StatusClientClosedRequest, rec.Code)
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	req = httptest.NewRequest("GET", "/recipes/", nil).WithContext(expired)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusGatewayTimeout, rec.Code)
+
+	require.Contains(t, logged.String(), "GET /recipes/: 499")
+	require.Contains(t, logged.String(), "GET /recipes/: 504")
 }
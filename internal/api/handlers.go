@@ -1,114 +1,356 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 )
 
-var body struct {
-	UserID string `json:"user_id"`
-}
+func newRecipeResource(store myDB.Store) *Resource[types.Recipe] {
+	err := EnsureRecipeSearch(context.Background(), store)
+	if err != nil && !errors.Is(err, ErrSearchUnavailable) {
+		log.Printf("recipe search is disabled: %v", err)
+	}
+	searchable := err == nil
 
-var CreateRecipe = HandlerByType(CreateByTypeWithRelations[types.Recipe])
-var UpdateRecipe = HandlerByType(UpdateByType[types.Recipe])
-var DeleteRecipe = DeleteHandlerByType[types.Recipe](DeleteByType[types.Recipe])
-var GetRecipe = GetHandlerByType(GetByType[types.Recipe])
-var GetManyRecipe = GetHandlerManyByType(GetManyByType[types.Recipe])
-var GetManyIngredients = GetAllHandlerManyByType(GetAllByType[types.Ingredient])
+	return NewResource(store, ResourceOptions[types.Recipe]{
+		Routes: func(r chi.Router) {
+			r.Get("/search", SearchRecipesHandler(store, searchable))
+			r.Get("/pantry", PantryHandler(store))
+			r.Post("/{id}/like", LikeRecipe(store))
+			r.Delete("/{id}/like", UnlikeRecipe(store))
+			r.Post("/{id}/views", UpdateViewRecipe(store))
+		},
+	})
+}
 
-func UnlikeRecipe(w http.ResponseWriter, r *http.Request) {
-	recipeID := chi.URLParam(r, "id")
+// GetManyIngredients answers GET /ingredients/ with every ingredient, or with
+// suggestions for what has been typed when q is given.
+func GetManyIngredients(store myDB.Store) http.HandlerFunc {
+	all := GetAllHandlerManyByType(func(ctx context.Context, opts QueryOptions) ([]types.Ingredient, error) {
+		return GetAllByTypeWithOptionsContext[types.Ingredient](ctx, store, opts)
+	})
+	suggest := SuggestIngredientsHandler(store)
 
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "invalid body", http.StatusBadRequest)
-		return
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("q") {
+			suggest(w, r)
+			return
+		}
+		all(w, r)
 	}
+}
 
-	if recipeID == "" || body.UserID == "" {
-		http.Error(w, "missing user_id or recipe_id", http.StatusBadRequest)
-		return
-	}
-	err := DeleteRelationByType[types.UserLikedRecipe](body.UserID, recipeID)
-	if err != nil {
-		http.Error(w, "could not unlike recipe: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	var recipe types.Recipe
-	recipe.ID = recipeID
-	err = UpdateCountByType(recipe, "likes", "-1")
+// SuggestIngredientsHandler answers GET /ingredients/?q=agu&limit=10 with the
+// best matching ingredients first.
+func SuggestIngredientsHandler(store myDB.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		limit := DefaultSuggestions
+		if raw := query.Get("limit"); raw != "" {
+			var err error
+			limit, err = strconv.Atoi(raw)
+			if err != nil || limit < 1 || limit > MaxSuggestions {
+				writeProblem(w, r, kindInvalidQueryOption, fmt.Sprintf("limit must be between 1 and %d", MaxSuggestions))
+				return
+			}
+		}
+
+		suggestions, err := SuggestIngredientsContext(r.Context(), store, query.Get("q"), limit)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	if err != nil {
-		http.Error(w, "could not decrement likes recipe: "+err.Error(), http.StatusInternalServerError)
-		return
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(suggestions)
 	}
+}
 
-	w.WriteHeader(http.StatusNoContent)
+// likeBody names the user liking or unliking a recipe.
+type likeBody struct {
+	UserID string `json:"user_id"`
 }
 
-func LikeRecipe(w http.ResponseWriter, r *http.Request) {
-	var recipe types.Recipe
-	recipeID := chi.URLParam(r, "id")
+func UnlikeRecipe(store myDB.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recipeID := chi.URLParam(r, "id")
 
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "invalid body", http.StatusBadRequest)
-		return
-	}
+		var body likeBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeProblem(w, r, kindInvalidJSON, "invalid JSON: "+err.Error())
+			return
+		}
 
-	if body.UserID == "" || recipeID == "" {
-		http.Error(w, "missing user_id or recipe_id", http.StatusBadRequest)
-		return
-	}
-	relations := []types.UserLikedRecipe{
-		{
-			UserID:   body.UserID,
-			RecipeID: recipeID,
-		},
+		if recipeID == "" || body.UserID == "" {
+			writeProblem(w, r, kindInvalidRequest, "missing user_id or recipe_id")
+			return
+		}
+		err := myDB.WithTxContext(r.Context(), store, func(tx myDB.Querier) error {
+			if err := DeleteRelationByTypeContext[types.UserLikedRecipe](r.Context(), tx, body.UserID, recipeID); err != nil {
+				return err
+			}
+			var recipe types.Recipe
+			recipe.ID = recipeID
+			return UpdateCountByTypeContext(r.Context(), tx, recipe, "likes", "-1")
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
 	}
+}
+
+func LikeRecipe(store myDB.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var recipe types.Recipe
+		recipeID := chi.URLParam(r, "id")
 
-	err := CreateManyToManyByType(body.UserID, relations)
+		var body likeBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeProblem(w, r, kindInvalidJSON, "invalid JSON: "+err.Error())
+			return
+		}
 
-	if err != nil {
-		http.Error(w, "could not like recipe: "+err.Error(), http.StatusInternalServerError)
-		return
+		if body.UserID == "" || recipeID == "" {
+			writeProblem(w, r, kindInvalidRequest, "missing user_id or recipe_id")
+			return
+		}
+		relations := []types.UserLikedRecipe{
+			{
+				UserID:   body.UserID,
+				RecipeID: recipeID,
+			},
+		}
+
+		recipe.ID = recipeID
+		err := myDB.WithTxContext(r.Context(), store, func(tx myDB.Querier) error {
+			if err := CreateManyToManyByTypeContext(r.Context(), tx, body.UserID, relations); err != nil {
+				return err
+			}
+			return UpdateCountByTypeContext(r.Context(), tx, recipe, "likes", "+1")
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
 	}
+}
 
-	recipe.ID = recipeID
-	err = UpdateCountByType(recipe, "likes", "+1")
+func UpdateViewRecipe(store myDB.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var recipe types.Recipe
+		recipeID := chi.URLParam(r, "id")
 
-	if err != nil {
-		http.Error(w, "could not increment likes recipe: "+err.Error(), http.StatusInternalServerError)
-		return
+		if recipeID == "" {
+			writeProblem(w, r, kindInvalidRequest, "missing recipe_id")
+			return
+		}
+		recipe.ID = recipeID
+		err := UpdateCountByTypeContext(r.Context(), store, recipe, "views", "+1")
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
 	}
+}
 
-	w.WriteHeader(http.StatusCreated)
+// SearchRecipesHandler answers GET /recipes/search?q= with the best matching
+// recipes first. Filters and pagination work as on the recipe list. Without
+// FTS5 in the build it answers 501.
+func SearchRecipesHandler(store myDB.Store, searchable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !searchable {
+			writeError(w, r, ErrSearchUnavailable)
+			return
+		}
+
+		query := r.URL.Query()
+		page, perPage, err := parsePagination(query)
+		if err != nil {
+			writeProblem(w, r, kindInvalidQueryOption, err.Error())
+			return
+		}
+
+		filters, err := ParseFilters(query)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		_, useCursor := query["cursor"]
+		opts := QueryOptions{Page: page, PerPage: perPage, Filters: filters, UseCursor: useCursor,
+			ExcludeIngredients: parseIDs(query.Get("exclude_ingredients")),
+			RequireIngredients: parseIDs(query.Get("require_ingredients")),
+		}
+		text := query.Get("q")
+
+		hits, err := SearchRecipesContext(r.Context(), store, text, opts)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		total, err := CountSearchRecipesContext(r.Context(), store, text, opts)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		resp := ListResponse[RecipeSearchHit]{
+			Items:   hits,
+			Page:    page,
+			PerPage: perPage,
+			Total:   total,
+			HasMore: page*perPage < total,
+		}
+		if resp.Items == nil {
+			resp.Items = []RecipeSearchHit{}
+		}
+
+		setLinkHeader(w, r, resp)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
 }
 
-func UpdateViewRecipe(w http.ResponseWriter, r *http.Request) {
-	var recipe types.Recipe
-	recipeID := chi.URLParam(r, "id")
+// PantryHandler answers GET /recipes/pantry?ingredients=id,id with the
+// recipes those ingredients cover best. max_missing bounds how many other
+// ingredients a recipe may need.
+func PantryHandler(store myDB.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		page, perPage, err := parsePagination(query)
+		if err != nil {
+			writeProblem(w, r, kindInvalidQueryOption, err.Error())
+			return
+		}
+
+		filters, err := ParseFilters(query)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		pantry := Pantry{Ingredients: parseIDs(query.Get("ingredients")), MaxMissing: -1}
+		if raw := query.Get("max_missing"); raw != "" {
+			pantry.MaxMissing, err = strconv.Atoi(raw)
+			if err != nil || pantry.MaxMissing < 0 {
+				writeProblem(w, r, kindInvalidQueryOption, "max_missing must be a whole number of at least 0")
+				return
+			}
+		}
+
+		opts := QueryOptions{Page: page, PerPage: perPage, Filters: filters,
+			ExcludeIngredients: parseIDs(query.Get("exclude_ingredients")),
+			RequireIngredients: parseIDs(query.Get("require_ingredients")),
+		}
+		matches, err := MatchPantryContext(r.Context(), store, pantry, opts)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	if recipeID == "" {
-		http.Error(w, "missing recipe_id", http.StatusBadRequest)
-		return
+		total, err := CountPantryMatchesContext(r.Context(), store, pantry, opts)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		resp := ListResponse[PantryMatch]{
+			Items:   matches,
+			Page:    page,
+			PerPage: perPage,
+			Total:   total,
+			HasMore: page*perPage < total,
+		}
+		if resp.Items == nil {
+			resp.Items = []PantryMatch{}
+		}
+
+		setLinkHeader(w, r, resp)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
 	}
-	recipe.ID = recipeID
-	err := UpdateCountByType(recipe, "views", "+1")
+}
 
-	if errors.Is(err, ErrNoIdForType) {
-		http.Error(w, "no id for the type: "+err.Error(), http.StatusBadRequest)
-		return
+// IngredientFamilyHandler answers GET /ingredients/{id}/family with the
+// ingredient, its parent, its children and its aliases.
+func IngredientFamilyHandler(store myDB.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		family, err := GetIngredientFamilyContext(r.Context(), store, chi.URLParam(r, "id"))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(family)
 	}
+}
+
+// SetIngredientParentHandler answers PUT /ingredients/{id}/parent with
+// {"parent_id": "..."} by placing the ingredient below that parent, and
+// returns its family.
+func SetIngredientParentHandler(store myDB.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
 
-	if err != nil {
-		fmt.Printf("Eec error: %v\n", err)
-		http.Error(w, "could not update views recipe: "+err.Error(), http.StatusInternalServerError)
-		return
+		var req struct {
+			ParentID string `json:"parent_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, kindInvalidJSON, "invalid JSON: "+err.Error())
+			return
+		}
+		if req.ParentID == "" {
+			writeProblem(w, r, kindInvalidRequest, "missing parent_id")
+			return
+		}
+
+		if err := SetIngredientParentContext(r.Context(), store, id, req.ParentID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		family, err := GetIngredientFamilyContext(r.Context(), store, id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(family)
 	}
+}
 
-	w.WriteHeader(http.StatusOK)
+// RemoveIngredientParentHandler answers DELETE /ingredients/{id}/parent by
+// moving the ingredient to the top of the taxonomy.
+func RemoveIngredientParentHandler(store myDB.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := RemoveIngredientParentContext(r.Context(), store, chi.URLParam(r, "id")); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
 }
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// MaxIngredientFilters bounds how many ingredients exclude_ingredients and
+// require_ingredients may list each.
+const MaxIngredientFilters = 50
+
+// parseIDs splits a comma separated list of ids, dropping empty ones.
+func parseIDs(raw string) []string {
+	var ids []string
+	for _, id := range strings.Split(raw, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ingredientConditions keeps the rows of table using none of the excluded
+// ingredients and every required one, as found in the ingredients relation
+// of t. Exclusion is an anti-join and every required ingredient a semi-join,
+// both looked up by the primary key of the relation's table. Both reach down
+// the taxonomy: excluding "Ost" excludes "Gedeost" too, and requiring it is
+// met by any cheese. Placeholders are numbered from argOffset+1.
+func ingredientConditions(d Dialect, t reflect.Type, table string, opts QueryOptions, argOffset int) ([]string, []any, error) {
+	if len(opts.ExcludeIngredients) == 0 && len(opts.RequireIngredients) == 0 {
+		return nil, nil, nil
+	}
+	if len(opts.ExcludeIngredients) > MaxIngredientFilters {
+		return nil, nil, &FilterError{Field: "exclude_ingredients", Op: "in", Err: ErrInvalidFilterValue}
+	}
+	if len(opts.RequireIngredients) > MaxIngredientFilters {
+		return nil, nil, &FilterError{Field: "require_ingredients", Op: "in", Err: ErrInvalidFilterValue}
+	}
+
+	rels, err := resolveRelations(t, []string{"ingredients"})
+	if err != nil {
+		return nil, nil, err
+	}
+	rel := rels[0]
+	uses := fmt.Sprintf("SELECT 1 FROM %s AS uses WHERE uses.%s = %s.%s AND uses.%s",
+		d.Quote(rel.table), d.Quote(rel.parentCol), d.Quote(table), d.Quote("id"), d.Quote(rel.keyCol))
+
+	var conditions []string
+	var args []any
+
+	if len(opts.ExcludeIngredients) > 0 {
+		for _, id := range opts.ExcludeIngredients {
+			args = append(args, id)
+		}
+		conditions = append(conditions, fmt.Sprintf("NOT EXISTS (%s IN (%s))",
+			uses, ingredientsBelow(d, placeholders(d, argOffset, len(args)))))
+	}
+
+	required := slices.Clone(opts.RequireIngredients)
+	slices.Sort(required)
+	for _, id := range slices.Compact(required) {
+		args = append(args, id)
+		conditions = append(conditions, fmt.Sprintf("EXISTS (%s IN (%s))",
+			uses, ingredientsBelow(d, []string{d.Placeholder(argOffset + len(args))})))
+	}
+
+	return conditions, args, nil
+}
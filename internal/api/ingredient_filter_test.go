@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opskrifter-backend/internal/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngredientFilters(t *testing.T) {
+	store := newTestStore(t)
+	ingredients, recipes := newPantryRecipes(t, store)
+
+	list := func(opts QueryOptions) []string {
+		opts.Page, opts.PerPage, opts.OrderBy = 1, 10, "name"
+		got, err := GetManyByType[types.Recipe](store, opts)
+		require.NoError(t, err)
+
+		total, err := CountByType[types.Recipe](store, opts)
+		require.NoError(t, err)
+		assert.Equal(t, len(got), total)
+
+		var names []string
+		for _, recipe := range got {
+			names = append(names, recipe.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"de"}, list(QueryOptions{ExcludeIngredients: []string{ingredients["a"]}}))
+	assert.Equal(t, []string{"ab"}, list(QueryOptions{ExcludeIngredients: []string{ingredients["c"], ingredients["e"]}}))
+	assert.Equal(t, []string{"abcd", "de"}, list(QueryOptions{RequireIngredients: []string{ingredients["d"]}}))
+	assert.Equal(t, []string{"abcd"}, list(QueryOptions{RequireIngredients: []string{ingredients["a"], ingredients["d"], ingredients["a"]}}))
+	assert.Empty(t, list(QueryOptions{RequireIngredients: []string{ingredients["a"], ingredients["e"]}}))
+	assert.Equal(t, []string{"abcd"}, list(QueryOptions{
+		RequireIngredients: []string{ingredients["a"]},
+		ExcludeIngredients: []string{ingredients["e"]},
+		Filters:            []Filter{{Field: "name", Op: "like", Values: []string{"cd"}}},
+	}))
+
+	_, err := DeleteByType[types.Recipe](store, recipes["de"])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abcd"}, list(QueryOptions{RequireIngredients: []string{ingredients["d"]}}), "trashed recipes are left out")
+
+	_, err = GetManyByType[types.Ingredient](store, QueryOptions{Page: 1, PerPage: 10, ExcludeIngredients: []string{"x"}})
+	assert.ErrorIs(t, err, ErrUnknownRelation, "only types with ingredients can be filtered by them")
+
+	many := make([]string, MaxIngredientFilters+1)
+	_, err = GetManyByType[types.Recipe](store, QueryOptions{Page: 1, PerPage: 10, ExcludeIngredients: many})
+	assert.ErrorIs(t, err, ErrInvalidFilterValue)
+}
+
+func TestRouteIngredientFilters(t *testing.T) {
+	store, router := newTestEnv(t)
+	ingredients, recipes := newPantryRecipes(t, store)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/recipes/?per_page=1&cursor=&exclude_ingredients=" + ingredients["e"] + "&require_ingredients=" + ingredients["a"])
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	var page ListResponse[types.Recipe]
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, 2, page.Total)
+	require.NotEmpty(t, page.NextCursor)
+
+	resp = get("/recipes/?per_page=1&cursor=" + page.NextCursor + "&exclude_ingredients=" + ingredients["e"] + "&require_ingredients=" + ingredients["a"])
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var next ListResponse[types.Recipe]
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &next))
+	require.Len(t, next.Items, 1)
+	assert.False(t, next.HasMore, "a full last page has no next page")
+	assert.Empty(t, next.NextCursor)
+	assert.ElementsMatch(t, []string{recipes["ab"], recipes["abcd"]}, []string{page.Items[0].ID, next.Items[0].ID})
+
+	resp = get("/recipes/pantry?ingredients=" + ingredients["d"] + "&exclude_ingredients=" + ingredients["a"])
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var matches ListResponse[PantryMatch]
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &matches))
+	require.Len(t, matches.Items, 1)
+	assert.Equal(t, recipes["de"], matches.Items[0].ID)
+
+	assert.Equal(t, http.StatusBadRequest, get("/recipes/?exclude_ingredients="+strings.Repeat("x,", MaxIngredientFilters+1)).Code)
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"log"
 	"net/http"
 	"opskrifter-backend/internal/testutils"
 	"opskrifter-backend/internal/types"
@@ -10,6 +9,8 @@ import (
 	"testing"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
 )
 
 var adminUser = types.User{
@@ -28,26 +29,39 @@ var (
 	testRecipes         []types.Recipe
 	testIngredients     []types.Ingredient
 	testSteps           []types.RecipeStep
-	testRouter          http.Handler
 	amount              int
 )
 
 func TestMain(m *testing.M) {
-	err := myDB.Init(true)
-	if err != nil {
-		log.Fatalf("error init DB %s", err)
-	}
-
-	id, err := CreateByType(adminUser)
-	if err != nil {
-		log.Fatal("error creating admin user")
-	}
-	adminUser.ID = id
+	adminUser.ID = uuid.NewString()
 
 	setupTestData()
-	code := m.Run()
-	myDB.DB.Close()
-	os.Exit(code)
+	os.Exit(m.Run())
+}
+
+// newTestStore returns a migrated database of its own that only holds the
+// admin user the test data belongs to.
+func newTestStore(t *testing.T) myDB.Store {
+	t.Helper()
+
+	store, err := myDB.NewMemoryStore()
+	require.NoError(t, err, "error creating store")
+	t.Cleanup(func() { store.Close() })
+
+	_, err = store.Exec(
+		"INSERT INTO users (id, name, email, status, created_at) VALUES (?, ?, ?, ?, ?)",
+		adminUser.ID, adminUser.Name, adminUser.Email, adminUser.Status, adminUser.CreatedAt,
+	)
+	require.NoError(t, err, "error creating admin user")
+
+	return store
+}
+
+func newTestEnv(t *testing.T) (myDB.Store, http.Handler) {
+	store := newTestStore(t)
+	r := chi.NewRouter()
+	setupRouter(r, store)
+	return store, r
 }
 
 func setupTestData() {
@@ -60,8 +74,4 @@ func setupTestData() {
 	handlerRecipe = recipeGenerator.Generate()
 	testSteps = recipeStepGenerator.GenerateMany(amount)
 	testIngredients = ingredientGenerator.GenerateMany(amount)
-
-	r := chi.NewRouter()
-	setupRouter(r)
-	testRouter = r
 }
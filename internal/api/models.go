@@ -0,0 +1,24 @@
+package api
+
+import (
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+)
+
+// Models are the stored types. Every one is checked against its table when a
+// store is opened, so a db tag without a column fails at startup instead of
+// on the first query.
+var Models = []myDB.Model{
+	types.Recipe{},
+	types.User{},
+	types.UserLikedRecipe{},
+	types.Ingredient{},
+	types.IngredientAlias{},
+	types.IngredientParent{},
+	types.RecipeIngredient{},
+	types.RecipeStep{},
+}
+
+func init() {
+	myDB.RegisterModels(Models...)
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"opskrifter-backend/internal/middleware"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"reflect"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var ErrNoNaturalKey = errors.New("no natural key for type")
+
+// naturalKey returns the columns tagged natural_key:"true" on obj and their
+// values, folded for fold:"column" fields. Together they identify a row as
+// well as its id does.
+func naturalKey(obj any) ([]string, []any, error) {
+	v := reflect.ValueOf(obj)
+	t := v.Type()
+	var columns []string
+	var values []any
+
+	for i := range t.NumField() {
+		if _, ok := t.Field(i).Tag.Lookup("natural_key"); !ok {
+			continue
+		}
+		col, ok := columnTag(t.Field(i))
+		if !ok {
+			continue
+		}
+
+		value := v.Field(i).Interface()
+		folded, isFolded, err := foldedValue(v, t.Field(i))
+		if err != nil {
+			return nil, nil, err
+		}
+		if isFolded {
+			value = folded
+		}
+		columns = append(columns, col)
+		values = append(values, value)
+	}
+	return columns, values, nil
+}
+
+// UpsertByType inserts obj, or overwrites the row with the same natural key,
+// and returns the id of the stored row.
+func UpsertByType[T types.Identifiable](q myDB.Querier, obj T) (string, error) {
+	return UpsertByTypeContext(context.Background(), q, obj)
+}
+
+func UpsertByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, obj T) (string, error) {
+	if err := Validate(obj); err != nil {
+		return "", err
+	}
+
+	conflict, _, err := naturalKey(obj)
+	if err != nil {
+		return "", err
+	}
+	if len(conflict) == 0 {
+		return "", ErrNoNaturalKey
+	}
+
+	query, args, _, err := BuildUpsertQuery(dialectOf(q), obj, conflict)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	if err := q.GetContext(ctx, &id, query, args...); err != nil {
+		return "", fmt.Errorf("failed to upsert: %w", err)
+	}
+	return id, nil
+}
+
+// GetOrCreateByType returns the id of the row with the natural key of obj,
+// inserting obj first when there is none. An existing row is left as it is.
+func GetOrCreateByType[T types.Identifiable](q myDB.Querier, obj T) (string, error) {
+	return GetOrCreateByTypeContext(context.Background(), q, obj)
+}
+
+func GetOrCreateByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, obj T) (string, error) {
+	if err := Validate(obj); err != nil {
+		return "", err
+	}
+
+	conflict, values, err := naturalKey(obj)
+	if err != nil {
+		return "", err
+	}
+	if len(conflict) == 0 {
+		return "", ErrNoNaturalKey
+	}
+
+	d := dialectOf(q)
+	query, args, _, err := BuildInsertQuery(d, obj)
+	if err != nil {
+		return "", err
+	}
+
+	query += d.Upsert(conflict, nil)
+	if _, err := q.ExecContext(ctx, query, args...); err != nil {
+		return "", fmt.Errorf("failed to insert: %w", err)
+	}
+
+	return idByColumns(ctx, q, obj.TableName(), conflict, values)
+}
+
+func idByColumns(ctx context.Context, q myDB.Querier, table string, columns []string, values []any) (string, error) {
+	d := dialectOf(q)
+	conditions := make([]string, len(columns))
+	for i, col := range columns {
+		conditions[i] = fmt.Sprintf("%s = %s", d.Quote(col), d.Placeholder(i+1))
+	}
+
+	var id string
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", d.Quote("id"), d.Quote(table), strings.Join(conditions, " AND "))
+	err := q.GetContext(ctx, &id, query, values...)
+	return id, err
+}
+
+type createMissingKey struct{}
+
+// WithCreateMissing lets writes run with ctx create the rows their relations
+// name by a joined column when there is none yet, instead of failing.
+func WithCreateMissing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, createMissingKey{}, true)
+}
+
+func createMissing(ctx context.Context) bool {
+	create, _ := ctx.Value(createMissingKey{}).(bool)
+	return create
+}
+
+// createMissingContext is ctx made with WithCreateMissing when r asks for it
+// with ?create_missing=true. Only admins may add to the shared catalogs.
+func createMissingContext(ctx context.Context, r *http.Request) context.Context {
+	if r.URL.Query().Get("create_missing") == "true" && middleware.IsAdmin(r) {
+		return WithCreateMissing(ctx)
+	}
+	return ctx
+}
+
+// resolveJoinedKeys lets relation rows reference their child by a joined
+// column instead of its id: a row with an empty child:"true" column and a
+// filled join:"table.column" field gets the id of the row in table with that
+// column, or else of the row an alias by that name stands for, see joinedID.
+// A row naming nothing is a validation error unless ctx comes from
+// WithCreateMissing, which creates it. The column, or its fold:"column"
+// column, has to be unique. obj is copied, never changed.
+func resolveJoinedKeys[T any](ctx context.Context, q myDB.Querier, obj T) (T, error) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Struct {
+		return obj, nil
+	}
+
+	out := reflect.New(v.Type()).Elem()
+	out.Set(v)
+
+	var unknown []FieldError
+	for _, rel := range relationsOf(v.Type()) {
+		rows := out.Field(rel.index)
+		if len(rel.joins) == 0 || rows.Len() == 0 {
+			continue
+		}
+
+		resolved := reflect.MakeSlice(rows.Type(), rows.Len(), rows.Len())
+		reflect.Copy(resolved, rows)
+
+		for i := range resolved.Len() {
+			row := resolved.Index(i)
+			if row.Field(rel.keyIdx).String() != "" {
+				continue
+			}
+
+			for _, join := range rel.joins {
+				value := row.Field(join.index).String()
+				if value == "" {
+					continue
+				}
+
+				id, err := joinedID(ctx, q, join, value)
+				if errors.Is(err, sql.ErrNoRows) {
+					if !createMissing(ctx) {
+						unknown = append(unknown, FieldError{
+							Field:   fmt.Sprintf("%s[%d].%s", jsonName(v.Type().Field(rel.index)), i, jsonName(rel.elemType.Field(join.index))),
+							Rule:    "exists",
+							Message: "is not in " + join.table,
+						})
+						break
+					}
+					id, err = createJoined(ctx, q, join, value)
+				}
+				if err != nil {
+					return obj, fmt.Errorf("failed to resolve %s %q: %w", rel.name, value, err)
+				}
+				row.Field(rel.keyIdx).SetString(id)
+				break
+			}
+		}
+
+		out.Field(rel.index).Set(resolved)
+	}
+
+	if len(unknown) > 0 {
+		return obj, &ValidationError{Fields: unknown}
+	}
+	return out.Interface().(T), nil
+}
+
+// joinedID returns the id of the row of join.table whose join.column is
+// value, and otherwise the id the alias called value in join.aliasTable
+// stands for. Names are compared by their fold:"column" column when the
+// table has one, so "æg" finds "Æg".
+func joinedID(ctx context.Context, q myDB.Querier, join relationJoin, value string) (string, error) {
+	d := dialectOf(q)
+	column, match := lookupColumn(join.table, join.column, value)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s ORDER BY %s LIMIT 1",
+		d.Quote("id"), d.Quote(join.table), d.Quote(column), d.Placeholder(1), d.Quote("id"))
+
+	var id string
+	err := q.GetContext(ctx, &id, query, match)
+	if join.aliasTable == "" || !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+
+	column, match = lookupColumn(join.aliasTable, join.column, value)
+	query = fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s ORDER BY %s LIMIT 1",
+		d.Quote(join.aliasKey), d.Quote(join.aliasTable), d.Quote(column), d.Placeholder(1), d.Quote("id"))
+	err = q.GetContext(ctx, &id, query, match)
+	return id, err
+}
+
+// lookupColumn returns the column of table to compare a value of column
+// with, and the value to compare: the folded column and value when table
+// has a fold:"column" column, or else column and value themselves.
+func lookupColumn(table string, column string, value string) (string, string) {
+	if folded, ok := foldedColumn(table, column); ok {
+		return folded, normalizeName(value)
+	}
+	return column, value
+}
+
+// createJoined inserts the row of join.table called value, unless one with
+// the same folded name got there first, and returns the id of either.
+func createJoined(ctx context.Context, q myDB.Querier, join relationJoin, value string) (string, error) {
+	d := dialectOf(q)
+	columns := []string{"id", join.column}
+	args := []any{uuid.NewString(), value}
+	conflict, match := lookupColumn(join.table, join.column, value)
+	if conflict != join.column {
+		columns = append(columns, conflict)
+		args = append(args, match)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", d.Quote(join.table),
+		strings.Join(quoteAll(d, columns), ", "),
+		strings.Join(placeholders(d, 0, len(args)), ", "),
+	) + d.Upsert([]string{conflict}, nil)
+
+	if _, err := q.ExecContext(ctx, query, args...); err != nil {
+		return "", err
+	}
+
+	return idByColumns(ctx, q, join.table, []string{conflict}, []any{match})
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opskrifter-backend/internal/middleware"
+	"opskrifter-backend/internal/testutils"
+	"opskrifter-backend/internal/types"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpsertAndGetOrCreateByType(t *testing.T) {
+	store := newTestStore(t)
+
+	id, err := UpsertByType(store, types.Ingredient{Name: "Agurk"})
+	require.NoError(t, err)
+	assert.Equal(t, "5", id, "existing row is reused")
+
+	id, err = GetOrCreateByType(store, types.Ingredient{Name: "Agurk"})
+	require.NoError(t, err)
+	assert.Equal(t, "5", id)
+
+	created, err := GetOrCreateByType(store, types.Ingredient{Name: "Dragefrugt"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, created)
+
+	again, err := UpsertByType(store, types.Ingredient{Name: "Dragefrugt"})
+	require.NoError(t, err)
+	assert.Equal(t, created, again)
+
+	id, err = GetOrCreateByType(store, types.Ingredient{Name: "ærter"})
+	require.NoError(t, err)
+	assert.Equal(t, "651", id, "natural keys are compared folded")
+
+	_, err = UpsertByType(store, recipeGenerator.Generate())
+	assert.ErrorIs(t, err, ErrNoNaturalKey)
+}
+
+func TestCreateRecipeWithIngredientNames(t *testing.T) {
+	store := newTestStore(t)
+	before, err := GetCountByType(store, types.Ingredient{})
+	require.NoError(t, err)
+
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeIngredients = []types.RecipeIngredient{
+		{Name: "agurk", Amount: "1 stk"},
+		{Name: "Stjerneanis", Amount: "2 stk"},
+	}
+	_, err = CreateByTypeWithRelations(store, recipe)
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr, "unknown names are only created on request")
+	assert.Equal(t, []FieldError{{Field: "RecipeIngredients[1].name", Rule: "exists", Message: "is not in ingredients"}}, validationErr.Fields)
+	require.NoError(t, testutils.AssertCountByType(store, before, GetCountByType[types.Ingredient]))
+
+	id, err := CreateByTypeWithRelationsContext(WithCreateMissing(t.Context()), store, recipe)
+	require.NoError(t, err)
+	assert.Equal(t, "", recipe.RecipeIngredients[0].IngredientId, "the caller's recipe is not changed")
+
+	stored, err := GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: []string{"ingredients"}})
+	require.NoError(t, err)
+	require.Len(t, stored.RecipeIngredients, 2)
+	assert.Equal(t, "5", stored.RecipeIngredients[0].IngredientId, "names are matched ignoring case")
+	assert.Equal(t, "Stjerneanis", stored.RecipeIngredients[1].Name)
+	require.NoError(t, testutils.AssertCountByType(store, before+1, GetCountByType[types.Ingredient]))
+
+	stored.RecipeIngredients = []types.RecipeIngredient{{Name: "Stjerneanis", Amount: "3 stk"}}
+	_, err = UpdateByTypeWithRelations(store, stored)
+	require.NoError(t, err, "a name of an ingredient already on the recipe updates that row")
+
+	stored, err = GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: []string{"ingredients"}})
+	require.NoError(t, err)
+	require.Len(t, stored.RecipeIngredients, 1)
+	assert.Equal(t, "3 stk", stored.RecipeIngredients[0].Amount)
+	require.NoError(t, testutils.AssertCountByType(store, before+1, GetCountByType[types.Ingredient]))
+
+	stored.RecipeIngredients = []types.RecipeIngredient{{Name: "Piskefløde", Amount: "2 dl"}}
+	_, err = UpdateByTypeWithRelationsContext(WithCreateMissing(t.Context()), store, stored)
+	require.NoError(t, err)
+
+	stored, err = GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: []string{"ingredients"}})
+	require.NoError(t, err)
+	require.Len(t, stored.RecipeIngredients, 1)
+	assert.Equal(t, seededFlode38, stored.RecipeIngredients[0].IngredientId, "aliases name ingredients too")
+	require.NoError(t, testutils.AssertCountByType(store, before+1, GetCountByType[types.Ingredient]))
+
+	stored.RecipeIngredients = []types.RecipeIngredient{{Name: "æg", Amount: "2 stk"}, {Name: "ØRRED", Amount: "1 stk"}}
+	_, err = UpdateByTypeWithRelationsContext(WithCreateMissing(t.Context()), store, stored)
+	require.NoError(t, err)
+
+	stored, err = GetByTypeWithOptions[types.Recipe](store, id, QueryOptions{Include: []string{"ingredients"}})
+	require.NoError(t, err)
+	require.Len(t, stored.RecipeIngredients, 2)
+	assert.Equal(t, "649", stored.RecipeIngredients[0].IngredientId, "æg is Æg")
+	assert.Equal(t, "Æg", stored.RecipeIngredients[0].Name)
+	assert.Equal(t, "652", stored.RecipeIngredients[1].IngredientId)
+	require.NoError(t, testutils.AssertCountByType(store, before+1, GetCountByType[types.Ingredient]), "no duplicate of Æg is created")
+
+	join := relationsOf(reflect.TypeOf(types.Recipe{}))[0].joins[0]
+	egg, err := createJoined(t.Context(), store, join, "æg")
+	require.NoError(t, err)
+	assert.Equal(t, "649", egg, "a row created in the meantime is reused")
+	require.NoError(t, testutils.AssertCountByType(store, before+1, GetCountByType[types.Ingredient]))
+}
+
+func TestRouteCreateRecipeWithIngredientNames(t *testing.T) {
+	store, router := newTestEnv(t)
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeIngredients = []types.RecipeIngredient{{Name: "Agurk", Amount: "1 stk"}}
+	body, _ := json.Marshal(recipe)
+
+	req := httptest.NewRequest("POST", "/recipes/", bytes.NewBuffer(body))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var created Response
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	stored, err := GetByTypeWithOptions[types.Recipe](store, created.ID, QueryOptions{Include: []string{"ingredients"}})
+	require.NoError(t, err)
+	require.Len(t, stored.RecipeIngredients, 1)
+	assert.Equal(t, "5", stored.RecipeIngredients[0].IngredientId)
+
+	t.Setenv("ADMIN_KEY", "secret")
+	post := func(target string, name string, key string) int {
+		recipe.RecipeIngredients = []types.RecipeIngredient{{Name: name, Amount: "1 stk"}}
+		body, _ := json.Marshal(recipe)
+		req := httptest.NewRequest("POST", target, bytes.NewBuffer(body))
+		req.Header.Set(middleware.AdminKeyHeader, key)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusUnprocessableEntity, post("/recipes/", "Dragefrugt", "secret"))
+	assert.Equal(t, http.StatusUnprocessableEntity, post("/recipes/?create_missing=true", "Dragefrugt", ""), "only admins add ingredients")
+	assert.Equal(t, http.StatusOK, post("/recipes/?create_missing=true", "Dragefrugt", "secret"))
+	assert.Equal(t, http.StatusUnprocessableEntity, post("/recipes/?create_missing=true", strings.Repeat("x", 101), "secret"), "new ingredients are validated")
+}
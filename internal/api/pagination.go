@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
+)
+
+// ListResponse is the envelope returned by list endpoints. Page is left out
+// in cursor mode, where NextCursor is set instead while there are more rows.
+type ListResponse[T any] struct {
+	Items      []T    `json:"items"`
+	Page       int    `json:"page,omitempty"`
+	PerPage    int    `json:"per_page"`
+	Total      int    `json:"total"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// parsePagination reads page and per_page, falling back to the defaults
+// when they are missing and clamping them to sane values.
+func parsePagination(query url.Values) (int, int, error) {
+	page, perPage := 1, DefaultPerPage
+
+	if raw := query.Get("page"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, 0, fmt.Errorf("err parsing page: %w", err)
+		}
+		page = max(parsed, 1)
+	}
+
+	if raw := query.Get("per_page"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, 0, fmt.Errorf("err parsing per_page: %w", err)
+		}
+		perPage = min(max(parsed, 1), MaxPerPage)
+	}
+
+	return page, perPage, nil
+}
+
+func lastPage(total int, perPage int) int {
+	return max((total+perPage-1)/perPage, 1)
+}
+
+func pageURL(r *http.Request, set map[string]string) string {
+	query := r.URL.Query()
+	for key, value := range set {
+		query.Set(key, value)
+	}
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// setLinkHeader sets an RFC 8288 Link header with the first, prev, next and
+// last pages of a list response.
+func setLinkHeader[T any](w http.ResponseWriter, r *http.Request, resp ListResponse[T]) {
+	var links []string
+	link := func(rel string, set map[string]string) {
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, pageURL(r, set), rel))
+	}
+
+	perPage := strconv.Itoa(resp.PerPage)
+	if resp.Page == 0 {
+		link("first", map[string]string{"cursor": "", "per_page": perPage})
+		if resp.NextCursor != "" {
+			link("next", map[string]string{"cursor": resp.NextCursor, "per_page": perPage})
+		}
+	} else {
+		link("first", map[string]string{"page": "1", "per_page": perPage})
+		if resp.Page > 1 {
+			link("prev", map[string]string{"page": strconv.Itoa(resp.Page - 1), "per_page": perPage})
+		}
+		if resp.HasMore {
+			link("next", map[string]string{"page": strconv.Itoa(resp.Page + 1), "per_page": perPage})
+		}
+		link("last", map[string]string{"page": strconv.Itoa(lastPage(resp.Total, resp.PerPage)), "per_page": perPage})
+	}
+
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
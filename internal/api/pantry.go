@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"reflect"
+)
+
+var ErrInvalidPantry = errors.New("invalid pantry")
+
+// MaxPantrySize bounds how many ingredients a pantry may list.
+const MaxPantrySize = 500
+
+// Pantry is what a user has at home. An ingredient covers the more general
+// ones above it in the taxonomy too, so "Fløde 38 %" covers "Fløde".
+// MaxMissing bounds how many ingredients a matching recipe may need beyond
+// them; a negative MaxMissing allows any.
+type Pantry struct {
+	Ingredients []string `json:"ingredients"`
+	MaxMissing  int      `json:"max_missing"`
+}
+
+// PantryMatch is a recipe using at least one ingredient of a pantry.
+// Coverage is the share of its ingredients found in the pantry, and Missing
+// lists the others.
+type PantryMatch struct {
+	types.Recipe
+	Matched  int                      `json:"matched" db:"matched"`
+	Total    int                      `json:"total_ingredients" db:"total_ingredients"`
+	Coverage float64                  `json:"coverage" db:"coverage"`
+	Missing  []types.RecipeIngredient `json:"missing" db:"-"`
+}
+
+// pantryFrom groups the live recipes with their ingredients, counting how
+// many of them are in the pantry, and keeps those that use at least one and
+// miss no more than allowed. The rows are named matches.
+func pantryFrom(d Dialect, pantry Pantry, opts QueryOptions) (string, []any, error) {
+	if len(pantry.Ingredients) == 0 || len(pantry.Ingredients) > MaxPantrySize {
+		return "", nil, fmt.Errorf("%w: list between 1 and %d ingredients", ErrInvalidPantry, MaxPantrySize)
+	}
+
+	var args []any
+	for _, id := range pantry.Ingredients {
+		args = append(args, id)
+	}
+
+	t := reflect.TypeOf(types.Recipe{})
+	recipes, uses := d.Quote("recipes"), d.Quote("ingredients_for_recipe")
+	grouped := fmt.Sprintf(`SELECT %[1]s.*, COUNT(*) AS total_ingredients,
+		SUM(CASE WHEN %[2]s.%[3]s IN (%[4]s) THEN 1 ELSE 0 END) AS matched
+		FROM %[1]s JOIN %[2]s ON %[2]s.%[5]s = %[1]s.%[6]s`,
+		recipes, uses, d.Quote("ingredient_id"), ingredientsAbove(d, placeholders(d, 0, len(args))),
+		d.Quote("recipe_id"), d.Quote("id"))
+
+	conditions, filterArgs, err := buildFilterConditions(d, t, opts.Filters, len(args))
+	if err != nil {
+		return "", nil, err
+	}
+	args = append(args, filterArgs...)
+	used, usedArgs, err := ingredientConditions(d, t, "recipes", opts, len(args))
+	if err != nil {
+		return "", nil, err
+	}
+	conditions, args = append(conditions, used...), append(args, usedArgs...)
+	conditions = append(conditions, liveCondition(d, t, false)...)
+	grouped += whereClause(conditions) + fmt.Sprintf(" GROUP BY %s.%s", recipes, d.Quote("id"))
+
+	from := fmt.Sprintf("(%s) AS matches WHERE matches.matched > 0", grouped)
+	if pantry.MaxMissing >= 0 {
+		args = append(args, pantry.MaxMissing)
+		from += " AND matches.total_ingredients - matches.matched <= " + d.Placeholder(len(args))
+	}
+	return from, args, nil
+}
+
+// BuildPantryQuery finds the recipes a pantry covers best: the largest share
+// of their ingredients first, then the fewest missing ones.
+func BuildPantryQuery(d Dialect, pantry Pantry, opts QueryOptions) (string, []any, error) {
+	from, args, err := pantryFrom(d, pantry, opts)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := fmt.Sprintf(`SELECT matches.*, CAST(matches.matched AS REAL) / matches.total_ingredients AS coverage
+		FROM %s ORDER BY coverage DESC, matches.total_ingredients - matches.matched, matches.%s LIMIT %s OFFSET %s`,
+		from, d.Quote("id"), d.Placeholder(len(args)+1), d.Placeholder(len(args)+2))
+	args = append(args, opts.PerPage, (opts.Page-1)*opts.PerPage)
+	return query, args, nil
+}
+
+// BuildPantryCountQuery counts the recipes BuildPantryQuery pages through.
+func BuildPantryCountQuery(d Dialect, pantry Pantry, opts QueryOptions) (string, []any, error) {
+	from, args, err := pantryFrom(d, pantry, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return "SELECT COUNT(*) FROM " + from, args, nil
+}
+
+func MatchPantry(q myDB.Querier, pantry Pantry, opts QueryOptions) ([]PantryMatch, error) {
+	return MatchPantryContext(context.Background(), q, pantry, opts)
+}
+
+// MatchPantryContext returns a page of the recipes matching pantry, each with
+// its ingredients and the ones missing from the pantry.
+func MatchPantryContext(ctx context.Context, q myDB.Querier, pantry Pantry, opts QueryOptions) ([]PantryMatch, error) {
+	query, args, err := BuildPantryQuery(dialectOf(q), pantry, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []PantryMatch
+	if err := q.SelectContext(ctx, &matches, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to match pantry: %w", err)
+	}
+
+	recipes := make([]types.Recipe, len(matches))
+	for i := range matches {
+		recipes[i] = matches[i].Recipe
+	}
+	if err := loadRelations(ctx, q, recipes, []string{"ingredients"}); err != nil {
+		return nil, err
+	}
+
+	covered, err := ingredientsAboveContext(ctx, q, pantry.Ingredients)
+	if err != nil {
+		return nil, err
+	}
+	have := map[string]bool{}
+	for _, id := range covered {
+		have[id] = true
+	}
+	for i := range matches {
+		matches[i].Recipe = recipes[i]
+		matches[i].Missing = []types.RecipeIngredient{}
+		for _, ingredient := range recipes[i].RecipeIngredients {
+			if !have[ingredient.IngredientId] {
+				matches[i].Missing = append(matches[i].Missing, ingredient)
+			}
+		}
+	}
+	return matches, nil
+}
+
+func CountPantryMatches(q myDB.Querier, pantry Pantry, opts QueryOptions) (int, error) {
+	return CountPantryMatchesContext(context.Background(), q, pantry, opts)
+}
+
+func CountPantryMatchesContext(ctx context.Context, q myDB.Querier, pantry Pantry, opts QueryOptions) (int, error) {
+	query, args, err := BuildPantryCountQuery(dialectOf(q), pantry, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int
+	if err := q.GetContext(ctx, &total, query, args...); err != nil {
+		return 0, fmt.Errorf("failed to count pantry matches: %w", err)
+	}
+	return total, nil
+}
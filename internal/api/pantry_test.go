@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPantryRecipes creates ingredients a to e and three recipes using them:
+// "ab" uses a and b, "abcd" uses a to d and "de" uses d and e.
+func newPantryRecipes(t *testing.T, store myDB.Store) (map[string]string, map[string]string) {
+	ingredients := map[string]string{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		id, err := CreateByType(store, types.Ingredient{Name: "Pantry " + name})
+		require.NoError(t, err)
+		ingredients[name] = id
+	}
+
+	recipes := map[string]string{}
+	for _, uses := range []string{"ab", "abcd", "de"} {
+		recipe := recipeGenerator.Generate()
+		recipe.Name = uses
+		for _, name := range uses {
+			recipe.RecipeIngredients = append(recipe.RecipeIngredients,
+				types.RecipeIngredient{IngredientId: ingredients[string(name)], Amount: "1 stk"})
+		}
+		id, err := CreateByTypeWithRelations(store, recipe)
+		require.NoError(t, err)
+		recipes[uses] = id
+	}
+	return ingredients, recipes
+}
+
+func TestMatchPantry(t *testing.T) {
+	store := newTestStore(t)
+	ingredients, recipes := newPantryRecipes(t, store)
+
+	pantry := Pantry{Ingredients: []string{ingredients["a"], ingredients["b"], ingredients["c"]}, MaxMissing: -1}
+	matches, err := MatchPantry(store, pantry, QueryOptions{Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	require.Len(t, matches, 2, "recipes using none of the pantry are left out")
+
+	assert.Equal(t, recipes["ab"], matches[0].ID)
+	assert.Equal(t, 1.0, matches[0].Coverage)
+	assert.Empty(t, matches[0].Missing)
+	assert.Len(t, matches[0].RecipeIngredients, 2)
+
+	assert.Equal(t, recipes["abcd"], matches[1].ID)
+	assert.Equal(t, 3, matches[1].Matched)
+	assert.Equal(t, 4, matches[1].Total)
+	assert.Equal(t, 0.75, matches[1].Coverage)
+	require.Len(t, matches[1].Missing, 1)
+	assert.Equal(t, ingredients["d"], matches[1].Missing[0].IngredientId)
+	assert.Equal(t, "Pantry d", matches[1].Missing[0].Name)
+
+	pantry.MaxMissing = 0
+	matches, err = MatchPantry(store, pantry, QueryOptions{Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.Equal(t, recipes["ab"], matches[0].ID)
+
+	total, err := CountPantryMatches(store, Pantry{Ingredients: []string{ingredients["d"]}, MaxMissing: -1}, QueryOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+
+	_, err = DeleteByType[types.Recipe](store, recipes["de"])
+	require.NoError(t, err)
+	total, err = CountPantryMatches(store, Pantry{Ingredients: []string{ingredients["d"]}, MaxMissing: -1}, QueryOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total, "trashed recipes are left out")
+
+	_, err = MatchPantry(store, Pantry{}, QueryOptions{Page: 1, PerPage: 10})
+	assert.ErrorIs(t, err, ErrInvalidPantry)
+}
+
+func TestRoutePantry(t *testing.T) {
+	store, router := newTestEnv(t)
+	ingredients, recipes := newPantryRecipes(t, store)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/recipes/pantry?"+query, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	pantry := strings.Join([]string{ingredients["d"], ingredients["e"], ingredients["a"]}, ",")
+	resp := get("ingredients=" + pantry + "&max_missing=2&per_page=1")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	var got ListResponse[PantryMatch]
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	require.Len(t, got.Items, 1)
+	assert.Equal(t, recipes["de"], got.Items[0].ID)
+	assert.Equal(t, 3, got.Total, "ab misses b, abcd misses b and c")
+	assert.True(t, got.HasMore)
+	assert.NotEmpty(t, resp.Header().Get("Link"))
+
+	assert.Equal(t, http.StatusOK, get("ingredients="+pantry+"&name=ab").Code)
+	assert.Equal(t, http.StatusBadRequest, get("ingredients=").Code)
+	assert.Equal(t, http.StatusBadRequest, get("ingredients="+pantry+"&max_missing=-1").Code)
+	assert.Equal(t, http.StatusBadRequest, get("ingredients="+pantry+"&max_missing=two").Code)
+}
@@ -0,0 +1,394 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+var ErrInvalidPatch = errors.New("invalid patch")
+var ErrPatchTestFailed = errors.New("patch test operation failed")
+
+// Patch changes the JSON document of a resource.
+type Patch interface {
+	Apply(doc any) (any, error)
+}
+
+// MergePatch is an RFC 7396 JSON Merge Patch document.
+type MergePatch map[string]any
+
+func (p MergePatch) Apply(doc any) (any, error) {
+	return mergePatch(doc, map[string]any(p)), nil
+}
+
+func mergePatch(target any, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+
+	return targetObj
+}
+
+// JSONPatch is an RFC 6902 JSON Patch document.
+type JSONPatch []PatchOperation
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (p JSONPatch) Apply(doc any) (any, error) {
+	var err error
+	for i, op := range p {
+		doc, err = op.apply(doc)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func (op PatchOperation) value() (any, error) {
+	if len(op.Value) == 0 {
+		return nil, fmt.Errorf("%w: missing value", ErrInvalidPatch)
+	}
+
+	var value any
+	if err := json.Unmarshal(op.Value, &value); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return value, nil
+}
+
+func (op PatchOperation) apply(doc any) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	case "remove":
+		return pointerRemove(doc, path)
+	case "replace":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pointerGet(doc, path); err != nil {
+			return nil, err
+		}
+		if doc, err = pointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+				return nil, fmt.Errorf("%w: cannot move a value into itself", ErrInvalidPatch)
+			}
+			if doc, err = pointerRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else if value, err = deepCopy(value); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	case "test":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		current, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrPatchTestFailed
+		}
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tokens[i], "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int) (int, error) {
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx > length || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+	return idx, nil
+}
+
+func pointerGet(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: %q does not exist", ErrInvalidPatch, token)
+			}
+			doc = value
+		case []any:
+			idx, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[idx]
+		default:
+			return nil, fmt.Errorf("%w: cannot index into %q", ErrInvalidPatch, token)
+		}
+	}
+	return doc, nil
+}
+
+// pointerUpdate walks to the parent of path and lets fn change it, setting
+// the returned container back into its own parent on the way up.
+func pointerUpdate(doc any, path []string, fn func(parent any, key string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := pointerGet(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	child, err = pointerUpdate(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node := doc.(type) {
+	case map[string]any:
+		node[path[0]] = child
+	case []any:
+		idx, _ := arrayIndex(path[0], len(node)-1)
+		node[idx] = child
+	}
+	return doc, nil
+}
+
+func pointerAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return pointerUpdate(doc, path, func(parent any, key string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[key] = value
+			return node, nil
+		case []any:
+			if key == "-" {
+				return append(node, value), nil
+			}
+			idx, err := arrayIndex(key, len(node))
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[idx+1:], node[idx:])
+			node[idx] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("%w: cannot add to %q", ErrInvalidPatch, key)
+	})
+}
+
+func pointerRemove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalidPatch)
+	}
+
+	return pointerUpdate(doc, path, func(parent any, key string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			if _, ok := node[key]; !ok {
+				return nil, fmt.Errorf("%w: %q does not exist", ErrInvalidPatch, key)
+			}
+			delete(node, key)
+			return node, nil
+		case []any:
+			idx, err := arrayIndex(key, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:idx], node[idx+1:]...), nil
+		}
+		return nil, fmt.Errorf("%w: cannot remove %q", ErrInvalidPatch, key)
+	})
+}
+
+func deepCopy(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	err = json.Unmarshal(data, &out)
+	return out, err
+}
+
+// patchColumns applies patch to the JSON form of obj and returns the stored
+// columns whose value changed, decoded into the field's Go type.
+func patchColumns(obj any, patch Patch) (map[string]any, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var before, after any
+	if err := json.Unmarshal(data, &before); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &after); err != nil {
+		return nil, err
+	}
+
+	after, err = patch.Apply(after)
+	if err != nil {
+		return nil, err
+	}
+
+	beforeObj := before.(map[string]any)
+	afterObj, ok := after.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: patched document is not an object", ErrInvalidPatch)
+	}
+
+	t := reflect.TypeOf(obj)
+	known := map[string]bool{}
+	changes := map[string]any{}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name := jsonName(field)
+		known[name] = true
+
+		value, ok := afterObj[name]
+		if !ok {
+			if _, had := beforeObj[name]; had {
+				return nil, fmt.Errorf("%w: %s cannot be removed", ErrInvalidPatch, name)
+			}
+			continue
+		}
+
+		if reflect.DeepEqual(beforeObj[name], value) {
+			continue
+		}
+
+		col, isColumn := columnTag(field)
+		_, isVersion := field.Tag.Lookup("version")
+		_, isSoftDelete := field.Tag.Lookup("soft_delete")
+		if !isColumn || col == "id" || isVersion || isSoftDelete || !isUpdatable(field) {
+			return nil, fmt.Errorf("%w: %s cannot be patched", ErrInvalidPatch, name)
+		}
+
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		typed := reflect.New(field.Type)
+		if err := json.Unmarshal(raw, typed.Interface()); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPatch, name, err)
+		}
+		changes[col] = typed.Elem().Interface()
+	}
+
+	for name := range afterObj {
+		if !known[name] {
+			return nil, fmt.Errorf("%w: unknown field %s", ErrInvalidPatch, name)
+		}
+	}
+
+	return changes, nil
+}
+
+// refold adds to changes the fold:"column" columns of obj whose column is
+// among them, folded from obj.
+func refold(obj any, changes map[string]any) error {
+	v := reflect.ValueOf(obj)
+	t := v.Type()
+	for i := range t.NumField() {
+		column, ok := t.Field(i).Tag.Lookup("fold")
+		if _, changed := changes[column]; !ok || !changed {
+			continue
+		}
+
+		folded, _, err := foldedValue(v, t.Field(i))
+		if err != nil {
+			return err
+		}
+		if col, ok := columnTag(t.Field(i)); ok {
+			changes[col] = folded
+		}
+	}
+	return nil
+}
+
+// patched returns a copy of obj with the columns changed by patchColumns.
+func patched(obj any, changes map[string]any) any {
+	v := reflect.New(reflect.TypeOf(obj)).Elem()
+	v.Set(reflect.ValueOf(obj))
+	for col, value := range changes {
+		if field, ok := fieldByColumn(v.Type(), col); ok {
+			v.FieldByIndex(field.Index).Set(reflect.ValueOf(value))
+		}
+	}
+	return v.Interface()
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONPatchApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		patch    string
+		expected string
+		err      error
+	}{
+		{
+			name:     "Replace",
+			doc:      `{"name": "a", "minutes": 10}`,
+			patch:    `[{"op": "replace", "path": "/minutes", "value": 20}]`,
+			expected: `{"name": "a", "minutes": 20}`,
+		},
+		{
+			name:     "Add to array",
+			doc:      `{"tags": ["a", "c"]}`,
+			patch:    `[{"op": "add", "path": "/tags/1", "value": "b"}, {"op": "add", "path": "/tags/-", "value": "d"}]`,
+			expected: `{"tags": ["a", "b", "c", "d"]}`,
+		},
+		{
+			name:     "Remove from array",
+			doc:      `{"tags": ["a", "b", "c"]}`,
+			patch:    `[{"op": "remove", "path": "/tags/0"}]`,
+			expected: `{"tags": ["b", "c"]}`,
+		},
+		{
+			name:     "Move and copy",
+			doc:      `{"a": {"b": 1}, "c": 2}`,
+			patch:    `[{"op": "move", "from": "/a/b", "path": "/d"}, {"op": "copy", "from": "/c", "path": "/a/e"}]`,
+			expected: `{"a": {"e": 2}, "c": 2, "d": 1}`,
+		},
+		{
+			name:     "Escaped pointer",
+			doc:      `{"a/b": 1, "m~n": 2}`,
+			patch:    `[{"op": "replace", "path": "/a~1b", "value": 3}, {"op": "remove", "path": "/m~0n"}]`,
+			expected: `{"a/b": 3}`,
+		},
+		{
+			name:     "Test passes",
+			doc:      `{"name": "a"}`,
+			patch:    `[{"op": "test", "path": "/name", "value": "a"}]`,
+			expected: `{"name": "a"}`,
+		},
+		{
+			name:  "Test fails",
+			doc:   `{"name": "a"}`,
+			patch: `[{"op": "test", "path": "/name", "value": "b"}]`,
+			err:   ErrPatchTestFailed,
+		},
+		{
+			name:  "Replace missing member",
+			doc:   `{"name": "a"}`,
+			patch: `[{"op": "replace", "path": "/missing", "value": 1}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "Missing value",
+			doc:   `{"name": "a"}`,
+			patch: `[{"op": "add", "path": "/other"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "Unknown op",
+			doc:   `{"name": "a"}`,
+			patch: `[{"op": "merge", "path": "/name"}]`,
+			err:   ErrInvalidPatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc any
+			var patch JSONPatch
+			require.NoError(t, json.Unmarshal([]byte(tt.doc), &doc))
+			require.NoError(t, json.Unmarshal([]byte(tt.patch), &patch))
+
+			got, err := patch.Apply(doc)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			var expected any
+			require.NoError(t, json.Unmarshal([]byte(tt.expected), &expected))
+			assert.Equal(t, expected, got)
+		})
+	}
+}
+
+func TestMergePatchApply(t *testing.T) {
+	var doc any
+	require.NoError(t, json.Unmarshal([]byte(`{"a": "b", "c": {"d": "e", "f": "g"}}`), &doc))
+
+	var patch MergePatch
+	require.NoError(t, json.Unmarshal([]byte(`{"a": "z", "c": {"f": null}}`), &patch))
+
+	got, err := patch.Apply(doc)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"a": "z", "c": map[string]any{"d": "e"}}, got)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"opskrifter-backend/internal/problem"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// problemKind is the type and status an error is answered with.
+type problemKind struct {
+	name   string
+	status int
+}
+
+var (
+	kindNotFound           = problemKind{"not-found", http.StatusNotFound}
+	kindInvalidRequest     = problemKind{"invalid-request", http.StatusBadRequest}
+	kindInvalidJSON        = problemKind{"invalid-json", http.StatusBadRequest}
+	kindUnsupportedPatch   = problemKind{"unsupported-patch-format", http.StatusUnsupportedMediaType}
+	kindRejected           = problemKind{"rejected", http.StatusBadRequest}
+	kindVersionMismatch    = problemKind{"version-mismatch", http.StatusPreconditionFailed}
+	kindPatchTestFailed    = problemKind{"patch-test-failed", http.StatusConflict}
+	kindConflict           = problemKind{"conflict", http.StatusConflict}
+	kindValidation         = problemKind{"validation-failed", http.StatusUnprocessableEntity}
+	kindConstraint         = problemKind{"constraint-violation", http.StatusUnprocessableEntity}
+	kindTimeout            = problemKind{"timeout", http.StatusGatewayTimeout}
+	kindClientClosed       = problemKind{"client-closed-request", StatusClientClosedRequest}
+	kindInternal           = problemKind{"internal", http.StatusInternalServerError}
+	kindMethodNotAllowed   = problemKind{"method-not-allowed", http.StatusMethodNotAllowed}
+	kindBatchAborted       = problemKind{"batch-aborted", http.StatusFailedDependency}
+	kindBatchNotAttempted  = problemKind{"batch-not-attempted", http.StatusFailedDependency}
+	kindInvalidQueryOption = problemKind{"invalid-query", http.StatusBadRequest}
+	kindSearchUnavailable  = problemKind{"search-unavailable", http.StatusNotImplemented}
+)
+
+// classify maps err to the problem it is answered with. public reports
+// whether the message of err may be shown to the client; database errors
+// are only logged since they can contain SQL.
+func classify(err error) (kind problemKind, public bool) {
+	var filterErr *FilterError
+	var sqliteErr sqlite3.Error
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, ErrRowsAffectedZero):
+		return kindNotFound, false
+	case errors.Is(err, ErrValidation):
+		return kindValidation, true
+	case errors.Is(err, ErrVersionMismatch):
+		return kindVersionMismatch, true
+	case errors.Is(err, ErrPatchTestFailed):
+		return kindPatchTestFailed, true
+	case errors.Is(err, ErrBatchAborted):
+		return kindBatchAborted, true
+	case errors.Is(err, ErrBatchNotAttempted):
+		return kindBatchNotAttempted, true
+	case errors.Is(err, ErrRejectedByHook):
+		return kindRejected, true
+	case errors.Is(err, ErrNotValidOrderBy), errors.Is(err, ErrNotValidField),
+		errors.Is(err, ErrUnknownRelation), errors.Is(err, ErrInvalidCursor),
+		errors.Is(err, ErrEmptySearch), errors.As(err, &filterErr):
+		return kindInvalidQueryOption, true
+	case errors.Is(err, ErrSearchUnavailable):
+		return kindSearchUnavailable, true
+	case errors.Is(err, ErrNoIdForType), errors.Is(err, ErrInvalidPatch),
+		errors.Is(err, ErrInvalidBatch), errors.Is(err, ErrNoNaturalKey),
+		errors.Is(err, ErrInvalidPantry):
+		return kindInvalidRequest, true
+	case errors.Is(err, ErrIngredientCycle):
+		return kindConstraint, true
+	case errors.Is(err, context.DeadlineExceeded):
+		return kindTimeout, false
+	case errors.Is(err, context.Canceled):
+		return kindClientClosed, false
+	case errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint:
+		switch sqliteErr.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+			return kindConflict, false
+		}
+		return kindConstraint, false
+	}
+	return kindInternal, false
+}
+
+// problemDetails are the details shown for the kinds whose error message is
+// not public.
+var problemDetails = map[problemKind]string{
+	kindNotFound:     "not found",
+	kindConflict:     "a row with the same unique value already exists",
+	kindConstraint:   "the data refers to a row that does not exist or misses a required value",
+	kindTimeout:      "operation timed out",
+	kindClientClosed: "request cancelled",
+	kindInternal:     "internal server error",
+}
+
+// problemOf turns err into the problem details it is answered with.
+func problemOf(err error) problem.Problem {
+	kind, public := classify(err)
+	detail := problemDetails[kind]
+	if public {
+		detail = err.Error()
+	}
+
+	p := problem.New(kind.name, kind.status, detail)
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		p.Errors = validationErr.Fields
+	}
+	return p
+}
+
+// writeError answers r with the problem err classifies as. Errors that end
+// up as 500 are logged with the request id, since the client does not see
+// them.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	p := problemOf(err)
+	switch p.Status {
+	case http.StatusInternalServerError, http.StatusGatewayTimeout, StatusClientClosedRequest:
+		log.Printf("request %s: %s %s: %d %v", problem.RequestID(r.Context()), r.Method, r.URL.Path, p.Status, err)
+	}
+	problem.Write(w, r, p)
+}
+
+// writeProblem answers r with a problem of kind that does not come from an
+// error, such as a missing path parameter.
+func writeProblem(w http.ResponseWriter, r *http.Request, kind problemKind, detail string) {
+	problem.Write(w, r, problem.New(kind.name, kind.status, detail))
+}
+
+// NotFoundHandler and MethodNotAllowedHandler answer requests the router has
+// no route for.
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, kindNotFound, "no route for "+r.URL.Path)
+}
+
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, kindMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
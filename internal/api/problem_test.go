@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"opskrifter-backend/internal/middleware"
+	"opskrifter-backend/internal/problem"
+	"opskrifter-backend/internal/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	store := newTestStore(t)
+	_, duplicate := store.Exec("INSERT INTO ingredients (id, name) VALUES ('dup', 'Agurk')")
+	_, missingUser := store.Exec("INSERT INTO user_liked_recipes (user_id, recipe_id) VALUES ('nobody', 'nothing')")
+
+	tests := []struct {
+		err    error
+		status int
+		kind   string
+	}{
+		{sql.ErrNoRows, http.StatusNotFound, "not-found"},
+		{ErrRowsAffectedZero, http.StatusNotFound, "not-found"},
+		{fmt.Errorf("failed: %w", ErrNotValidOrderBy), http.StatusBadRequest, "invalid-query"},
+		{&FilterError{Field: "x", Err: ErrUnknownFilterField}, http.StatusBadRequest, "invalid-query"},
+		{ErrNoIdForType, http.StatusBadRequest, "invalid-request"},
+		{ErrVersionMismatch, http.StatusPreconditionFailed, "version-mismatch"},
+		{&ValidationError{Fields: []FieldError{{Field: "name"}}}, http.StatusUnprocessableEntity, "validation-failed"},
+		{fmt.Errorf("failed to insert: %w", duplicate), http.StatusConflict, "conflict"},
+		{missingUser, http.StatusUnprocessableEntity, "constraint-violation"},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout, "timeout"},
+		{fmt.Errorf("near \"SELEC\": syntax error"), http.StatusInternalServerError, "internal"},
+	}
+
+	for _, tt := range tests {
+		p := problemOf(tt.err)
+		assert.Equal(t, tt.status, p.Status, tt.err.Error())
+		assert.Equal(t, problem.TypeBase+tt.kind, p.Type, tt.err.Error())
+	}
+
+	assert.NotContains(t, problemOf(duplicate).Detail, "UNIQUE", "database errors are not shown")
+}
+
+func TestRouteProblems(t *testing.T) {
+	_, router := newTestEnv(t)
+	handler := middleware.RequestID(router)
+
+	do := func(method string, target string, body any, requestID string) (*httptest.ResponseRecorder, problem.Problem) {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewBuffer(data))
+		req.Header.Set(problem.RequestIDHeader, requestID)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		var p problem.Problem
+		json.Unmarshal(resp.Body.Bytes(), &p)
+		return resp, p
+	}
+
+	resp, p := do("GET", "/recipes/does-not-exist", nil, "req-1")
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Equal(t, problem.ContentType, resp.Header().Get("Content-Type"))
+	assert.Equal(t, "req-1", resp.Header().Get(problem.RequestIDHeader))
+	assert.Equal(t, problem.Problem{
+		Type:      problem.TypeBase + "not-found",
+		Title:     "Not Found",
+		Status:    http.StatusNotFound,
+		Detail:    "not found",
+		Instance:  "/recipes/does-not-exist",
+		RequestID: "req-1",
+	}, p)
+
+	resp, p = do("POST", "/users/", types.User{Name: "Kopi", Email: adminUser.Email}, "")
+	require.Equal(t, http.StatusConflict, resp.Code, "duplicate email")
+	assert.NotEmpty(t, p.RequestID, "an id is made up when the client sends none")
+	assert.Equal(t, resp.Header().Get(problem.RequestIDHeader), p.RequestID)
+
+	recipe := recipeGenerator.Generate()
+	recipe.UserID = "nobody"
+	resp, p = do("POST", "/recipes/", recipe, "")
+	require.Equal(t, http.StatusUnprocessableEntity, resp.Code, "unknown user")
+	assert.Equal(t, problem.TypeBase+"constraint-violation", p.Type)
+
+	resp, p = do("GET", "/recipes/?order_by=nonsense", nil, "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, problem.TypeBase+"invalid-query", p.Type)
+
+	resp, p = do("GET", "/nowhere", nil, "")
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Equal(t, "/nowhere", p.Instance)
+
+	resp, p = do("PUT", "/ingredients/", nil, "")
+	require.Equal(t, http.StatusMethodNotAllowed, resp.Code)
+	assert.Equal(t, problem.TypeBase+"method-not-allowed", p.Type)
+}
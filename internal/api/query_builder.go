@@ -5,15 +5,30 @@ import (
 	"fmt"
 	"opskrifter-backend/internal/types"
 	"reflect"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/google/uuid"
 )
 
 type QueryOptions struct {
-	Page    int    `json:"page"`
-	PerPage int    `json:"per_page"`
-	OrderBy string `json:"order_by"`
+	Page    int      `json:"page"`
+	PerPage int      `json:"per_page"`
+	OrderBy string   `json:"order_by"`
+	Include []string `json:"include"`
+	Fields  []string `json:"fields"`
+	Filters []Filter `json:"filters"`
+	// UseCursor switches from LIMIT/OFFSET to keyset pagination starting
+	// after Cursor, or at the beginning when Cursor is empty.
+	UseCursor bool   `json:"use_cursor"`
+	Cursor    string `json:"cursor"`
+	// Trashed lists the soft deleted rows instead of the live ones.
+	Trashed bool `json:"trashed"`
+	// ExcludeIngredients keeps the rows using none of these ingredients and
+	// RequireIngredients the rows using all of them.
+	ExcludeIngredients []string `json:"exclude_ingredients"`
+	RequireIngredients []string `json:"require_ingredients"`
 }
 
 var validOrderBys = map[string]bool{
@@ -31,41 +46,102 @@ var ErrNotValidOrderBy = errors.New("this order by does not exist")
 var ErrNoColumnNamesFound = errors.New("no column names found")
 var ErrNoIdForType = errors.New("no id for type")
 
-func BuildInsertQuery(obj any) (string, []any, string) {
+func BuildInsertQuery(d Dialect, obj any) (string, []any, string, error) {
 	v := reflect.ValueOf(obj)
 	t := reflect.TypeOf(obj)
 	columns := []string{}
-	placeholders := []string{}
 	values := []any{}
 	id := uuid.New().String()
 
 	for i := range v.NumField() {
-		field := t.Field(i)
-		dbTag := field.Tag.Get("db")
-		if dbTag == "" {
+		dbTag, ok := columnTag(t.Field(i))
+		if !ok {
 			continue
 		}
+		if isReadOnly(t.Field(i)) {
+			continue
+		}
+
 		val := v.Field(i).Interface()
 
 		if dbTag == "id" {
 			val = id
 		}
 
+		now, ok, err := serverValue(t.Field(i))
+		if err != nil {
+			return "", nil, "", err
+		}
+		if ok {
+			val = now
+		}
+
+		folded, ok, err := foldedValue(v, t.Field(i))
+		if err != nil {
+			return "", nil, "", err
+		}
+		if ok {
+			val = folded
+		}
+
+		if _, ok := t.Field(i).Tag.Lookup("version"); ok {
+			val = 1
+		}
+
+		if _, ok := t.Field(i).Tag.Lookup("soft_delete"); ok {
+			val = nil
+		}
+
 		columns = append(columns, dbTag)
-		placeholders = append(placeholders, "?")
 		values = append(values, val)
 	}
 
 	table := obj.(types.Identifiable).TableName()
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table,
-		strings.Join(columns, ", "),
-		strings.Join(placeholders, ", "),
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", d.Quote(table),
+		strings.Join(quoteAll(d, columns), ", "),
+		strings.Join(placeholders(d, 0, len(values)), ", "),
 	)
 
-	return query, values, id
+	return query, values, id, nil
+}
+
+// BuildUpsertQuery inserts obj, or updates the row that already holds the
+// same conflict columns, and returns the id of the stored row either way.
+func BuildUpsertQuery(d Dialect, obj any, conflict []string) (string, []any, string, error) {
+	query, values, id, err := BuildInsertQuery(d, obj)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	var update []string
+	var bump string
+	t := reflect.TypeOf(obj)
+	for i := range t.NumField() {
+		dbTag, ok := columnTag(t.Field(i))
+		if _, isVersion := t.Field(i).Tag.Lookup("version"); ok && isVersion {
+			table := d.Quote(obj.(types.Identifiable).TableName())
+			bump = fmt.Sprintf(", %s = %s.%s + 1", d.Quote(dbTag), table, d.Quote(dbTag))
+			continue
+		}
+		if ok && dbTag != "id" && isUpdatable(t.Field(i)) && !slices.Contains(conflict, dbTag) {
+			update = append(update, dbTag)
+		}
+	}
+
+	// Updating the conflict columns to themselves keeps RETURNING working
+	// when there is nothing else to update.
+	if len(update) == 0 {
+		update = conflict
+	}
+
+	query += d.Upsert(conflict, update) + bump + d.Returning([]string{"id"})
+	return query, values, id, nil
 }
 
-func BuildUpdateQuery(obj any) (string, []any) {
+// BuildUpdateQuery overwrites the columns of the row with the id of obj that
+// the client owns. Versioned types get their version bumped, and a non-zero
+// version in obj must match the stored one.
+func BuildUpdateQuery(d Dialect, obj any) (string, []any, error) {
 	v := reflect.ValueOf(obj)
 	t := reflect.TypeOf(obj)
 
@@ -74,11 +150,12 @@ func BuildUpdateQuery(obj any) (string, []any) {
 
 	var idValue any
 	var idColumn string
+	var versionCol string
+	var version int64
 
 	for i := range v.NumField() {
-		field := t.Field(i)
-		dbTag := field.Tag.Get("db")
-		if dbTag == "" {
+		dbTag, ok := columnTag(t.Field(i))
+		if !ok {
 			continue
 		}
 
@@ -89,39 +166,189 @@ func BuildUpdateQuery(obj any) (string, []any) {
 			continue
 		}
 
-		assignments = append(assignments, fmt.Sprintf("%s = ?", dbTag))
+		if _, ok := t.Field(i).Tag.Lookup("version"); ok {
+			versionCol = dbTag
+			version = v.Field(i).Int()
+			assignments = append(assignments, fmt.Sprintf("%s = %s + 1", d.Quote(dbTag), d.Quote(dbTag)))
+			continue
+		}
+
+		folded, isFolded, err := foldedValue(v, t.Field(i))
+		if err != nil {
+			return "", nil, err
+		}
+		if isFolded {
+			val = folded
+		} else if _, ok := t.Field(i).Tag.Lookup("soft_delete"); ok || !isUpdatable(t.Field(i)) {
+			continue
+		}
+
 		values = append(values, val)
+		assignments = append(assignments, fmt.Sprintf("%s = %s", d.Quote(dbTag), d.Placeholder(len(values))))
 	}
 
 	values = append(values, idValue)
 	table := obj.(types.Identifiable).TableName()
-	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", table,
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s", d.Quote(table),
 		strings.Join(assignments, ", "),
-		idColumn,
-	)
+		d.Quote(idColumn),
+		d.Placeholder(len(values)),
+	) + andLive(d, t)
+
+	if versionCol != "" && version > 0 {
+		values = append(values, version)
+		query += fmt.Sprintf(" AND %s = %s", d.Quote(versionCol), d.Placeholder(len(values)))
+	}
+	return query, values, nil
+}
+
+// BuildPatchQuery updates only the given columns of the row stored as obj.
+// The column names must come from db tags, never from user input. For
+// versioned types the row must still have the version of obj.
+func BuildPatchQuery(d Dialect, obj any, changes map[string]any) (string, []any) {
+	columns := make([]string, 0, len(changes))
+	for col := range changes {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+
+	assignments := []string{}
+	values := []any{}
+	for _, col := range columns {
+		values = append(values, changes[col])
+		assignments = append(assignments, fmt.Sprintf("%s = %s", d.Quote(col), d.Placeholder(len(values))))
+	}
+
+	field, versioned := versionColumn(reflect.TypeOf(obj))
+	var versionCol string
+	if versioned {
+		versionCol, _ = columnTag(field)
+		assignments = append(assignments, fmt.Sprintf("%s = %s + 1", d.Quote(versionCol), d.Quote(versionCol)))
+	}
+
+	identifiable := obj.(types.Identifiable)
+	values = append(values, identifiable.GetID())
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s", d.Quote(identifiable.TableName()),
+		strings.Join(assignments, ", "),
+		d.Quote("id"),
+		d.Placeholder(len(values)),
+	) + andLive(d, reflect.TypeOf(obj))
+
+	if versioned {
+		version, _ := versionOf(obj)
+		values = append(values, version)
+		query += fmt.Sprintf(" AND %s = %s", d.Quote(versionCol), d.Placeholder(len(values)))
+	}
 	return query, values
 }
 
-func BuildQuery(tableName string, opts QueryOptions) (string, []any, error) {
+func BuildQuery(d Dialect, obj any, opts QueryOptions) (string, []any, error) {
 	offset := (opts.Page - 1) * opts.PerPage
-	var args []any
-	query := fmt.Sprintf("SELECT * FROM %s", tableName)
+	t := reflect.TypeOf(obj)
+	tableName := obj.(types.Identifiable).TableName()
 
 	if opts.OrderBy != "" && !validOrderBys[opts.OrderBy] {
 		return "", nil, ErrNotValidOrderBy
 	}
 
+	if _, ok := fieldByColumn(t, cursorOrderBy(opts.OrderBy)); !ok {
+		return "", nil, ErrNotValidOrderBy
+	}
+
+	required := []string{"id"}
+	if opts.UseCursor {
+		required = append(required, cursorOrderBy(opts.OrderBy))
+	}
+
+	columns, err := selectColumns(d, t, opts.Fields, required...)
+	if err != nil {
+		return "", nil, err
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", columns, d.Quote(tableName))
+
+	conditions, args, err := buildFilterConditions(d, t, opts.Filters, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	used, usedArgs, err := ingredientConditions(d, t, tableName, opts, len(args))
+	if err != nil {
+		return "", nil, err
+	}
+	conditions, args = append(conditions, used...), append(args, usedArgs...)
+	conditions = append(conditions, liveCondition(d, t, opts.Trashed)...)
+
+	id := d.Quote("id")
+
+	if opts.UseCursor {
+		orderBy := cursorOrderBy(opts.OrderBy)
+
+		if opts.Cursor != "" {
+			value, cursorID, err := decodeCursor(t, opts.Cursor, opts.OrderBy)
+			if err != nil {
+				return "", nil, err
+			}
+
+			if orderBy == "id" {
+				conditions = append(conditions, fmt.Sprintf("%s > %s", id, d.Placeholder(len(args)+1)))
+				args = append(args, cursorID)
+			} else {
+				conditions = append(conditions, fmt.Sprintf("(%s, %s) > (%s, %s)", d.Quote(orderBy), id, d.Placeholder(len(args)+1), d.Placeholder(len(args)+2)))
+				args = append(args, value, cursorID)
+			}
+		}
+
+		query += whereClause(conditions)
+		if orderBy == "id" {
+			query += " ORDER BY " + id
+		} else {
+			query += fmt.Sprintf(" ORDER BY %s, %s", d.Quote(orderBy), id)
+		}
+
+		query += fmt.Sprintf(" LIMIT %s", d.Placeholder(len(args)+1))
+		args = append(args, opts.PerPage)
+
+		return query, args, nil
+	}
+
+	query += whereClause(conditions)
+
 	if opts.OrderBy != "" && validOrderBys[opts.OrderBy] {
-		query += fmt.Sprintf(" ORDER BY %s", opts.OrderBy)
+		query += fmt.Sprintf(" ORDER BY %s", d.Quote(opts.OrderBy))
 	}
 
-	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	query += fmt.Sprintf(" LIMIT %s OFFSET %s", d.Placeholder(len(args)+1), d.Placeholder(len(args)+2))
 	args = append(args, opts.PerPage, offset)
 
 	return query, args, nil
 }
 
-func BuildQueryRelationsByType[E types.OneToMany](parentID string, elements []E) (string, []any, error) {
+// BuildCountQuery counts the rows matching the filters of opts, ignoring
+// pagination.
+func BuildCountQuery(d Dialect, obj any, opts QueryOptions) (string, []any, error) {
+	tableName := obj.(types.Identifiable).TableName()
+	conditions, args, err := buildFilterConditions(d, reflect.TypeOf(obj), opts.Filters, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	used, usedArgs, err := ingredientConditions(d, reflect.TypeOf(obj), tableName, opts, len(args))
+	if err != nil {
+		return "", nil, err
+	}
+	conditions, args = append(conditions, used...), append(args, usedArgs...)
+	conditions = append(conditions, liveCondition(d, reflect.TypeOf(obj), opts.Trashed)...)
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", d.Quote(tableName)) + whereClause(conditions)
+	return query, args, nil
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+func BuildQueryRelationsByType[E types.OneToMany](d Dialect, parentID string, elements []E) (string, []any, error) {
 	if len(elements) == 0 {
 		return "", nil, fmt.Errorf("no elements provided")
 	}
@@ -130,7 +357,7 @@ func BuildQueryRelationsByType[E types.OneToMany](parentID string, elements []E)
 	first := reflect.ValueOf(elements[0])
 	elemType := first.Type()
 
-	var placeholders []string
+	var rows []string
 	var args []any
 	columnNames, err := GetColumnNames(elements[0])
 	if err != nil {
@@ -143,8 +370,8 @@ func BuildQueryRelationsByType[E types.OneToMany](parentID string, elements []E)
 
 		for i := 0; i < elemType.NumField(); i++ {
 			field := elemType.Field(i)
-			dbTag := field.Tag.Get("db")
-			if dbTag == "" {
+			dbTag, ok := columnTag(field)
+			if !ok {
 				continue
 			}
 
@@ -157,17 +384,17 @@ func BuildQueryRelationsByType[E types.OneToMany](parentID string, elements []E)
 				args = append(args, val.Field(i).Interface())
 			}
 
-			rowPlaceholders = append(rowPlaceholders, "?")
+			rowPlaceholders = append(rowPlaceholders, d.Placeholder(len(args)))
 		}
 
-		placeholders = append(placeholders, fmt.Sprintf("(%s)", strings.Join(rowPlaceholders, ", ")))
+		rows = append(rows, fmt.Sprintf("(%s)", strings.Join(rowPlaceholders, ", ")))
 	}
 
 	query := fmt.Sprintf(
 		"INSERT INTO %s (%s) VALUES %s",
-		relationTable,
-		strings.Join(columnNames, ", "),
-		strings.Join(placeholders, ", "),
+		d.Quote(relationTable),
+		strings.Join(quoteAll(d, columnNames), ", "),
+		strings.Join(rows, ", "),
 	)
 	return query, args, nil
 }
@@ -178,9 +405,7 @@ func GetColumnNames[E any](element E) ([]string, error) {
 	var columnNames []string
 
 	for i := 0; i < elemType.NumField(); i++ {
-		field := elemType.Field(i)
-		dbTag := field.Tag.Get("db")
-		if dbTag != "" {
+		if dbTag, ok := columnTag(elemType.Field(i)); ok {
 			columnNames = append(columnNames, dbTag)
 		}
 	}
@@ -191,3 +416,13 @@ func GetColumnNames[E any](element E) ([]string, error) {
 
 	return columnNames, nil
 }
+
+// columnTag returns the column a field is stored in. Fields without a db tag
+// and fields filled from a joined table (join tag) are not stored.
+func columnTag(field reflect.StructField) (string, bool) {
+	dbTag := field.Tag.Get("db")
+	if dbTag == "" || field.Tag.Get("join") != "" {
+		return "", false
+	}
+	return dbTag, true
+}
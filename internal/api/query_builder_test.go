@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/url"
+	"opskrifter-backend/internal/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildQueryFilters(t *testing.T) {
+	query, err := url.ParseQuery("page=1&per_page=10&minutes[lte]=30&recipe_cuisine[in]=dansk,italiensk&name[like]=50%25_off")
+	require.NoError(t, err)
+
+	filters, err := ParseFilters(query)
+	require.NoError(t, err)
+
+	sql, args, err := BuildQuery(SQLite, types.Recipe{}, QueryOptions{Page: 1, PerPage: 10, OrderBy: "minutes", Filters: filters})
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM "recipes" WHERE "minutes" <= ? AND "name" LIKE ? ESCAPE '\' AND "recipe_cuisine" IN (?, ?) AND "deleted_at" IS NULL ORDER BY "minutes" LIMIT ? OFFSET ?`, sql)
+	assert.Equal(t, []any{int64(30), `%50\%\_off%`, "dansk", "italiensk", 10, 0}, args)
+}
+
+func TestBuildQueryFilterErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		err    error
+	}{
+		{
+			name:   "Unknown field",
+			filter: Filter{Field: "password", Op: "eq", Values: []string{"x"}},
+			err:    ErrUnknownFilterField,
+		},
+		{
+			name:   "Field without filter tag",
+			filter: Filter{Field: "description", Op: "eq", Values: []string{"x"}},
+			err:    ErrUnknownFilterField,
+		},
+		{
+			name:   "Operator not allowed on field",
+			filter: Filter{Field: "recipe_cuisine", Op: "lt", Values: []string{"x"}},
+			err:    ErrFilterNotAllowed,
+		},
+		{
+			name:   "Unknown operator",
+			filter: Filter{Field: "minutes", Op: "between", Values: []string{"1"}},
+			err:    ErrFilterNotAllowed,
+		},
+		{
+			name:   "Value of wrong type",
+			filter: Filter{Field: "minutes", Op: "lte", Values: []string{"soon"}},
+			err:    ErrInvalidFilterValue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := BuildQuery(SQLite, types.Recipe{}, QueryOptions{Page: 1, PerPage: 10, Filters: []Filter{tt.filter}})
+			require.ErrorIs(t, err, tt.err)
+
+			var filterErr *FilterError
+			require.ErrorAs(t, err, &filterErr)
+			assert.Equal(t, tt.filter.Field, filterErr.Field)
+		})
+	}
+}
+
+func TestBuildQueryCursor(t *testing.T) {
+	last := types.Recipe{ID: "b", Minutes: 20}
+	cursor, err := EncodeCursor(last, "minutes")
+	require.NoError(t, err)
+
+	filters := []Filter{{Field: "recipe_cuisine", Op: "eq", Values: []string{"dansk"}}}
+	sql, args, err := BuildQuery(SQLite, types.Recipe{}, QueryOptions{PerPage: 3, OrderBy: "minutes", Filters: filters, UseCursor: true, Cursor: cursor})
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM "recipes" WHERE "recipe_cuisine" = ? AND "deleted_at" IS NULL AND ("minutes", "id") > (?, ?) ORDER BY "minutes", "id" LIMIT ?`, sql)
+	assert.Equal(t, []any{"dansk", 20, "b", 3}, args)
+
+	sql, args, err = BuildQuery(SQLite, types.Recipe{}, QueryOptions{PerPage: 3, UseCursor: true})
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM "recipes" WHERE "deleted_at" IS NULL ORDER BY "id" LIMIT ?`, sql)
+	assert.Equal(t, []any{3}, args)
+
+	_, _, err = BuildQuery(SQLite, types.Recipe{}, QueryOptions{PerPage: 3, OrderBy: "name", UseCursor: true, Cursor: cursor})
+	require.ErrorIs(t, err, ErrInvalidCursor, "cursor must match order_by")
+
+	_, _, err = BuildQuery(SQLite, types.Recipe{}, QueryOptions{PerPage: 3, UseCursor: true, Cursor: "not a cursor"})
+	require.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestBuildQueryFields(t *testing.T) {
+	sql, _, err := BuildQuery(SQLite, types.Recipe{}, QueryOptions{Page: 1, PerPage: 10, Fields: []string{"name", "image"}})
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT "name", "image", "id" FROM "recipes" WHERE "deleted_at" IS NULL LIMIT ? OFFSET ?`, sql)
+
+	sql, _, err = BuildQuery(SQLite, types.Recipe{}, QueryOptions{PerPage: 10, OrderBy: "likes", Fields: []string{"id", "name"}, UseCursor: true})
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT "id", "name", "likes" FROM "recipes" WHERE "deleted_at" IS NULL ORDER BY "likes", "id" LIMIT ?`, sql)
+
+	_, _, err = BuildQuery(SQLite, types.Recipe{}, QueryOptions{Page: 1, PerPage: 10, Fields: []string{"name", "ingredients"}})
+	require.ErrorIs(t, err, ErrNotValidField)
+}
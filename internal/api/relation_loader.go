@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"reflect"
+	"strings"
+)
+
+var ErrUnknownRelation = errors.New("unknown relation")
+
+var oneToManyType = reflect.TypeOf((*types.OneToMany)(nil)).Elem()
+
+// relation is a slice field on a parent type whose elements are stored in
+// their own table and point back to the parent through a parent:"true" column.
+// Its name, used by ?include=, comes from a relation:"name" tag and defaults
+// to the JSON key of the field. Rows with a position:"true" column are kept
+// in the order of the field.
+type relation struct {
+	name      string
+	index     int
+	elemType  reflect.Type
+	table     string
+	parentCol string
+	parentIdx int
+	keyCol    string
+	keyIdx    int
+	posCol    string
+	posIdx    int
+	joins     []relationJoin
+}
+
+// relationJoin fills a join:"table.column" field by joining table on the
+// relation's child:"true" column. An aliases:"table.column" tag on the field
+// names the table holding other names for the rows of the joined table in a
+// column of the same name, and the column of the id each name stands for.
+type relationJoin struct {
+	table      string
+	column     string
+	alias      string
+	on         string
+	index      int
+	aliasTable string
+	aliasKey   string
+}
+
+func relationsOf(t reflect.Type) []relation {
+	var rels []relation
+	if t.Kind() != reflect.Struct {
+		return rels
+	}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Type.Kind() != reflect.Slice || !field.Type.Elem().Implements(oneToManyType) {
+			continue
+		}
+
+		elem := field.Type.Elem()
+		name := field.Tag.Get("relation")
+		if name == "" {
+			name = jsonName(field)
+		}
+		rel := relation{
+			name:      name,
+			index:     i,
+			elemType:  elem,
+			table:     reflect.Zero(elem).Interface().(types.OneToMany).TableName(),
+			parentIdx: -1,
+			keyIdx:    -1,
+			posIdx:    -1,
+		}
+
+		childCol := ""
+		for j := range elem.NumField() {
+			f := elem.Field(j)
+			if _, ok := f.Tag.Lookup("parent"); ok {
+				rel.parentCol = f.Tag.Get("db")
+				rel.parentIdx = j
+			}
+			if _, ok := f.Tag.Lookup("child"); ok {
+				childCol = f.Tag.Get("db")
+				rel.keyCol = childCol
+				rel.keyIdx = j
+			}
+			if _, ok := f.Tag.Lookup("position"); ok {
+				rel.posCol = f.Tag.Get("db")
+				rel.posIdx = j
+			}
+		}
+
+		// Rows without a child column are identified by their own id.
+		if rel.keyIdx < 0 {
+			for j := range elem.NumField() {
+				if elem.Field(j).Tag.Get("db") == "id" {
+					rel.keyCol = "id"
+					rel.keyIdx = j
+				}
+			}
+		}
+
+		for j := range elem.NumField() {
+			f := elem.Field(j)
+			join := f.Tag.Get("join")
+			table, column, found := strings.Cut(join, ".")
+			if !found || childCol == "" {
+				continue
+			}
+			aliasTable, aliasKey, _ := strings.Cut(f.Tag.Get("aliases"), ".")
+			rel.joins = append(rel.joins, relationJoin{
+				table:      table,
+				column:     column,
+				alias:      f.Tag.Get("db"),
+				on:         childCol,
+				index:      j,
+				aliasTable: aliasTable,
+				aliasKey:   aliasKey,
+			})
+		}
+
+		if rel.parentIdx < 0 {
+			continue
+		}
+		rels = append(rels, rel)
+	}
+
+	return rels
+}
+
+// RelationNames lists the names accepted by ?include= for T.
+func RelationNames[T types.Identifiable]() []string {
+	var obj T
+	var names []string
+	for _, rel := range relationsOf(reflect.TypeOf(obj)) {
+		names = append(names, rel.name)
+	}
+	return names
+}
+
+func resolveRelations(t reflect.Type, include []string) ([]relation, error) {
+	rels := relationsOf(t)
+	var selected []relation
+
+	for _, name := range include {
+		found := false
+		for _, rel := range rels {
+			if rel.name == name {
+				selected = append(selected, rel)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownRelation, name)
+		}
+	}
+
+	return selected, nil
+}
+
+func (rel relation) selectQuery(d Dialect, parents int) string {
+	table := d.Quote(rel.table)
+	columns := []string{table + ".*"}
+	joins := ""
+	for _, j := range rel.joins {
+		joined := d.Quote(j.table)
+		columns = append(columns, fmt.Sprintf("%s.%s AS %s", joined, d.Quote(j.column), d.Quote(j.alias)))
+		joins += fmt.Sprintf(" LEFT JOIN %s ON %s.%s = %s.%s", joined, joined, d.Quote("id"), table, d.Quote(j.on))
+	}
+
+	// Without a position the insertion order is the best guess at the order
+	// the children were written in.
+	order := d.InsertionOrder(rel.table)
+	if rel.posCol != "" {
+		order = table + "." + d.Quote(rel.posCol)
+	}
+
+	return fmt.Sprintf("SELECT %s FROM %s%s WHERE %s.%s IN (%s) ORDER BY %s",
+		strings.Join(columns, ", "),
+		table,
+		joins,
+		table,
+		d.Quote(rel.parentCol),
+		strings.Join(placeholders(d, 0, parents), ", "),
+		order,
+	)
+}
+
+// loadRelations fills the included relation fields of every obj with one
+// query per relation.
+func loadRelations[T types.Identifiable](ctx context.Context, q myDB.Querier, objs []T, include []string) error {
+	if len(include) == 0 {
+		return nil
+	}
+
+	var zero T
+	rels, err := resolveRelations(reflect.TypeOf(zero), include)
+	if err != nil {
+		return err
+	}
+
+	if len(objs) == 0 {
+		return nil
+	}
+
+	ids := make([]any, len(objs))
+	for i := range objs {
+		ids[i] = objs[i].GetID()
+	}
+
+	for _, rel := range rels {
+		dest := reflect.New(reflect.SliceOf(rel.elemType))
+		err := q.SelectContext(ctx, dest.Interface(), rel.selectQuery(dialectOf(q), len(ids)), ids...)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", rel.name, err)
+		}
+
+		rows := dest.Elem()
+		grouped := map[string]reflect.Value{}
+		for i := range rows.Len() {
+			row := rows.Index(i)
+			parentID := row.Field(rel.parentIdx).String()
+			children, ok := grouped[parentID]
+			if !ok {
+				children = reflect.MakeSlice(rows.Type(), 0, 1)
+			}
+			grouped[parentID] = reflect.Append(children, row)
+		}
+
+		for i := range objs {
+			children, ok := grouped[objs[i].GetID()]
+			if !ok {
+				children = reflect.MakeSlice(rows.Type(), 0, 0)
+			}
+			reflect.ValueOf(&objs[i]).Elem().Field(rel.index).Set(children)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"reflect"
+	"strings"
+)
+
+// relationDiff is what has to happen to the stored children of one parent
+// for them to match the payload.
+type relationDiff struct {
+	added   []types.OneToMany
+	changed []reflect.Value
+	removed []reflect.Value
+}
+
+// valueFields are the stored fields of a child row that are neither the
+// parent reference nor the key identifying the row.
+func (rel relation) valueFields() []int {
+	var fields []int
+	for i := range rel.elemType.NumField() {
+		if i == rel.parentIdx || i == rel.keyIdx {
+			continue
+		}
+		if _, ok := columnTag(rel.elemType.Field(i)); ok {
+			fields = append(fields, i)
+		}
+	}
+	return fields
+}
+
+func diffRelation(rel relation, existing reflect.Value, wanted reflect.Value) relationDiff {
+	var diff relationDiff
+	stored := map[string]reflect.Value{}
+	for i := range existing.Len() {
+		row := existing.Index(i)
+		stored[row.Field(rel.keyIdx).String()] = row
+	}
+
+	seen := map[string]bool{}
+	for i := range wanted.Len() {
+		row := wanted.Index(i)
+		key := row.Field(rel.keyIdx).String()
+		old, ok := stored[key]
+		if key == "" || !ok {
+			diff.added = append(diff.added, row.Interface().(types.OneToMany))
+			continue
+		}
+
+		seen[key] = true
+		for _, f := range rel.valueFields() {
+			if !reflect.DeepEqual(old.Field(f).Interface(), row.Field(f).Interface()) {
+				diff.changed = append(diff.changed, row)
+				break
+			}
+		}
+	}
+
+	for i := range existing.Len() {
+		row := existing.Index(i)
+		if !seen[row.Field(rel.keyIdx).String()] {
+			diff.removed = append(diff.removed, row)
+		}
+	}
+
+	return diff
+}
+
+func applyRelationDiff(ctx context.Context, q myDB.Querier, rel relation, parentID string, diff relationDiff) error {
+	d := dialectOf(q)
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE %s = %s AND %s = %s", d.Quote(rel.table),
+		d.Quote(rel.parentCol), d.Placeholder(1),
+		d.Quote(rel.keyCol), d.Placeholder(2),
+	)
+	for _, row := range diff.removed {
+		if _, err := q.ExecContext(ctx, deleteQuery, parentID, row.Field(rel.keyIdx).Interface()); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", rel.name, err)
+		}
+	}
+
+	if len(diff.changed) > 0 {
+		var assignments []string
+		fields := rel.valueFields()
+		for i, f := range fields {
+			col, _ := columnTag(rel.elemType.Field(f))
+			assignments = append(assignments, fmt.Sprintf("%s = %s", d.Quote(col), d.Placeholder(i+1)))
+		}
+
+		updateQuery := fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s AND %s = %s", d.Quote(rel.table),
+			strings.Join(assignments, ", "),
+			d.Quote(rel.parentCol), d.Placeholder(len(fields)+1),
+			d.Quote(rel.keyCol), d.Placeholder(len(fields)+2),
+		)
+
+		for _, row := range diff.changed {
+			var args []any
+			for _, f := range fields {
+				args = append(args, row.Field(f).Interface())
+			}
+			args = append(args, parentID, row.Field(rel.keyIdx).Interface())
+
+			if _, err := q.ExecContext(ctx, updateQuery, args...); err != nil {
+				return fmt.Errorf("failed to update %s: %w", rel.name, err)
+			}
+		}
+	}
+
+	if err := CreateManyToManyByTypeContext(ctx, q, parentID, diff.added); err != nil {
+		return fmt.Errorf("failed to add %s: %w", rel.name, err)
+	}
+
+	return nil
+}
+
+// numberRows copies obj with the position column of every relation row set
+// to the index of the row, so that reordering a relation is a change like
+// any other. obj itself is not changed.
+func numberRows[T any](obj T) T {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Struct {
+		return obj
+	}
+
+	out := reflect.New(v.Type()).Elem()
+	out.Set(v)
+	for _, rel := range relationsOf(v.Type()) {
+		rows := out.Field(rel.index)
+		if rel.posIdx < 0 || rows.IsNil() {
+			continue
+		}
+
+		numbered := reflect.MakeSlice(rows.Type(), rows.Len(), rows.Len())
+		reflect.Copy(numbered, rows)
+		for i := range numbered.Len() {
+			numbered.Index(i).Field(rel.posIdx).SetInt(int64(i))
+		}
+		out.Field(rel.index).Set(numbered)
+	}
+	return out.Interface().(T)
+}
+
+// syncRelations makes the stored children of obj match its relation fields,
+// in their order. A nil relation field leaves the stored children untouched,
+// an empty one removes them all.
+func syncRelations[T types.Identifiable](ctx context.Context, q myDB.Querier, obj T) error {
+	obj = numberRows(obj)
+	v := reflect.ValueOf(obj)
+	for _, rel := range relationsOf(v.Type()) {
+		wanted := v.Field(rel.index)
+		if wanted.IsNil() {
+			continue
+		}
+
+		if rel.keyIdx < 0 {
+			return fmt.Errorf("relation %s has no key to diff on", rel.name)
+		}
+
+		existing := reflect.New(reflect.SliceOf(rel.elemType))
+		if err := q.SelectContext(ctx, existing.Interface(), rel.selectQuery(dialectOf(q), 1), obj.GetID()); err != nil {
+			return fmt.Errorf("failed to load %s: %w", rel.name, err)
+		}
+
+		diff := diffRelation(rel, existing.Elem(), wanted)
+		if err := applyRelationDiff(ctx, q, rel, obj.GetID(), diff); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
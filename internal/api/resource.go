@@ -0,0 +1,270 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"opskrifter-backend/internal/middleware"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ErrRejectedByHook can be wrapped by a Before hook to answer 400 instead
+// of 500.
+var ErrRejectedByHook = errors.New("rejected")
+
+type Verb string
+
+const (
+	VerbList   Verb = "list"
+	VerbGet    Verb = "get"
+	VerbCreate Verb = "create"
+	VerbUpdate Verb = "update"
+	VerbPatch  Verb = "patch"
+	VerbDelete Verb = "delete"
+	VerbBatch  Verb = "batch"
+)
+
+var AllVerbs = []Verb{VerbList, VerbGet, VerbCreate, VerbUpdate, VerbPatch, VerbDelete, VerbBatch}
+
+// ResourceHooks run around the CRUD functions of a resource. A Before hook
+// returning an error aborts the operation. Batches do not run the hooks.
+type ResourceHooks[T types.Identifiable] struct {
+	BeforeCreate func(obj *T) error
+	AfterCreate  func(obj T, id string)
+	BeforeUpdate func(obj *T) error
+	AfterUpdate  func(obj T)
+	BeforeDelete func(id string) error
+	AfterDelete  func(id string)
+}
+
+type ResourceOptions[T types.Identifiable] struct {
+	// Verbs that are mounted, all of them when empty.
+	Verbs      []Verb
+	Middleware []func(http.Handler) http.Handler
+	Hooks      ResourceHooks[T]
+	// Timeout shortens OperationTimeout for the routes of this resource.
+	Timeout time.Duration
+	// List replaces the default paginated list handler.
+	List http.HandlerFunc
+	// Routes mounts extra routes next to the generic ones.
+	Routes func(r chi.Router)
+}
+
+// Resource holds the generic handlers of T. Relation aware writes are used
+// when T has relations.
+type Resource[T types.Identifiable] struct {
+	List   http.HandlerFunc
+	Get    http.HandlerFunc
+	Create http.HandlerFunc
+	Update http.HandlerFunc
+	Patch  http.HandlerFunc
+	Delete http.HandlerFunc
+	// Trash and Restore are only mounted for soft deleted types.
+	Trash   http.HandlerFunc
+	Restore http.HandlerFunc
+	Batch   http.HandlerFunc
+
+	store myDB.Store
+	opts  ResourceOptions[T]
+}
+
+func NewResource[T types.Identifiable](store myDB.Store, opts ResourceOptions[T]) *Resource[T] {
+	hooks := opts.Hooks
+
+	create := func(ctx context.Context, obj T) (string, error) {
+		if hooks.BeforeCreate != nil {
+			if err := hooks.BeforeCreate(&obj); err != nil {
+				return "", err
+			}
+		}
+
+		var id string
+		var err error
+		if withRelations, ok := any(obj).(types.IdentifiableWithRelations); ok {
+			id, err = CreateByTypeWithRelationsContext(ctx, store, withRelations)
+		} else {
+			id, err = CreateByTypeContext(ctx, store, obj)
+		}
+
+		if err == nil && hooks.AfterCreate != nil {
+			hooks.AfterCreate(obj, id)
+		}
+		return id, err
+	}
+
+	update := func(ctx context.Context, obj T) (string, error) {
+		if hooks.BeforeUpdate != nil {
+			if err := hooks.BeforeUpdate(&obj); err != nil {
+				return "", err
+			}
+		}
+
+		var id string
+		var err error
+		if withRelations, ok := any(obj).(types.IdentifiableWithRelations); ok {
+			id, err = UpdateByTypeWithRelationsContext(ctx, store, withRelations)
+		} else {
+			id, err = UpdateByTypeContext(ctx, store, obj)
+		}
+
+		if err == nil && hooks.AfterUpdate != nil {
+			hooks.AfterUpdate(obj)
+		}
+		return id, err
+	}
+
+	deleteFunc := func(ctx context.Context, id string) (string, error) {
+		if hooks.BeforeDelete != nil {
+			if err := hooks.BeforeDelete(id); err != nil {
+				return "", err
+			}
+		}
+
+		id, err := DeleteByTypeContext[T](ctx, store, id)
+		if err == nil && hooks.AfterDelete != nil {
+			hooks.AfterDelete(id)
+		}
+		return id, err
+	}
+
+	get := func(ctx context.Context, id string, queryOpts QueryOptions) (T, error) {
+		return GetByTypeWithOptionsContext[T](ctx, store, id, queryOpts)
+	}
+
+	patch := func(ctx context.Context, id string, patch Patch) (string, error) {
+		return PatchByTypeContext[T](ctx, store, id, patch)
+	}
+
+	list := opts.List
+	if list == nil {
+		list = GetHandlerManyByType(
+			func(ctx context.Context, queryOpts QueryOptions) ([]T, error) {
+				return GetManyByTypeContext[T](ctx, store, queryOpts)
+			},
+			func(ctx context.Context, queryOpts QueryOptions) (int, error) {
+				return CountByTypeContext[T](ctx, store, queryOpts)
+			},
+		)
+	}
+
+	trash := GetHandlerManyByType(
+		func(ctx context.Context, queryOpts QueryOptions) ([]T, error) {
+			queryOpts.Trashed = true
+			return GetManyByTypeContext[T](ctx, store, queryOpts)
+		},
+		func(ctx context.Context, queryOpts QueryOptions) (int, error) {
+			queryOpts.Trashed = true
+			return CountByTypeContext[T](ctx, store, queryOpts)
+		},
+	)
+
+	// Restoring answers the same way as deleting.
+	restore := func(ctx context.Context, id string) (string, error) {
+		return RestoreByTypeContext[T](ctx, store, id)
+	}
+
+	batch := func(ctx context.Context, mode BatchMode, ops []BatchOperation[T]) ([]BatchItem, error) {
+		return BatchByTypeContext(ctx, store, mode, ops)
+	}
+
+	return &Resource[T]{
+		List:    list,
+		Get:     GetHandlerWithOptionsByType(get),
+		Create:  HandlerByType(create),
+		Update:  HandlerByType(update),
+		Patch:   PatchHandlerByType[T](patch),
+		Delete:  DeleteHandlerByType[T](deleteFunc),
+		Trash:   trash,
+		Restore: DeleteHandlerByType[T](restore),
+		Batch:   BatchHandlerByType(batch),
+		store:   store,
+		opts:    opts,
+	}
+}
+
+func (res *Resource[T]) enabled(verb Verb) bool {
+	return len(res.opts.Verbs) == 0 || slices.Contains(res.opts.Verbs, verb)
+}
+
+// Mount adds the enabled routes of the resource to r, along with a
+// GET /{id}/<relation> route for every relation of T. Soft deleted types also
+// get GET /trash and POST /{id}/restore next to DELETE.
+func (res *Resource[T]) Mount(r chi.Router) {
+	if res.opts.Timeout > 0 {
+		r.Use(middleware.Timeout(res.opts.Timeout))
+	}
+	if len(res.opts.Middleware) > 0 {
+		r.Use(res.opts.Middleware...)
+	}
+
+	if res.enabled(VerbList) {
+		r.Get("/", res.List)
+	}
+	if res.enabled(VerbCreate) {
+		r.Post("/", res.Create)
+	}
+	if res.enabled(VerbUpdate) {
+		r.Put("/", res.Update)
+	}
+	if res.enabled(VerbBatch) {
+		r.Post("/batch", res.Batch)
+	}
+	if res.enabled(VerbPatch) {
+		r.Patch("/{id}", res.Patch)
+	}
+	if res.enabled(VerbDelete) {
+		r.Delete("/{id}", res.Delete)
+
+		var zero T
+		if _, ok := softDeleteColumn(reflect.TypeOf(zero)); ok {
+			r.Get("/trash", res.Trash)
+			r.Post("/{id}/restore", res.Restore)
+		}
+	}
+	if res.enabled(VerbGet) {
+		r.Get("/{id}", res.Get)
+
+		var zero T
+		for _, rel := range relationsOf(reflect.TypeOf(zero)) {
+			r.Get("/{id}/"+rel.name, relationHandler[T](res.store, rel))
+		}
+	}
+
+	if res.opts.Routes != nil {
+		res.opts.Routes(r)
+	}
+}
+
+// RegisterResource mounts the generic routes of T under pattern.
+func RegisterResource[T types.Identifiable](r chi.Router, store myDB.Store, pattern string, opts ResourceOptions[T]) *Resource[T] {
+	res := NewResource(store, opts)
+	r.Route(pattern, res.Mount)
+	return res
+}
+
+func relationHandler[T types.Identifiable](store myDB.Store, rel relation) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if id == "" {
+			writeProblem(w, r, kindInvalidRequest, "missing id")
+			return
+		}
+
+		obj, err := GetByTypeWithOptionsContext[T](r.Context(), store, id, QueryOptions{Fields: []string{"id"}, Include: []string{rel.name}})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(reflect.ValueOf(obj).Field(rel.index).Interface())
+	}
+}
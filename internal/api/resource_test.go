@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"opskrifter-backend/internal/types"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterResourceUsers(t *testing.T) {
+	store, router := newTestEnv(t)
+	user := types.User{Name: "Resource User", Email: "resource@example.com", Status: "active", CreatedAt: "now"}
+	body, _ := json.Marshal(user)
+	req := httptest.NewRequest("POST", "/users/", bytes.NewBuffer(body))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var created Response
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+
+	req = httptest.NewRequest("GET", "/users/"+created.ID, nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var got types.User
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	assert.Equal(t, "resource@example.com", got.Email)
+
+	req = httptest.NewRequest("DELETE", "/users/"+created.ID, nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	_, err := GetByType[types.User](store, created.ID)
+	assert.Error(t, err)
+}
+
+func TestRegisterResourceVerbs(t *testing.T) {
+	_, router := newTestEnv(t)
+	body, _ := json.Marshal(types.Ingredient{Name: "Not allowed"})
+	req := httptest.NewRequest("POST", "/ingredients/", bytes.NewBuffer(body))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.Code)
+
+	req = httptest.NewRequest("GET", "/users/", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.Code)
+
+	req = httptest.NewRequest("GET", "/ingredients/1", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var ingredient types.Ingredient
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &ingredient))
+	assert.Equal(t, "1", ingredient.ID)
+}
+
+func TestRegisterResourceRelationRoute(t *testing.T) {
+	store, router := newTestEnv(t)
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeSteps = []types.RecipeStep{{Step: "first"}, {Step: "second"}}
+	id, err := CreateByTypeWithRelations(store, recipe)
+	require.NoError(t, err)
+	defer DeleteByType[types.Recipe](store, id)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/recipes/%s/steps", id), nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var steps []types.RecipeStep
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &steps))
+	require.Len(t, steps, 2)
+	assert.Equal(t, "first", steps[0].Step)
+
+	req = httptest.NewRequest("GET", "/steps/"+steps[1].ID, nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest("GET", "/recipes/does-not-exist/steps", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	body, _ := json.Marshal(types.RecipeStep{ID: "does-not-exist", RecipeID: id, Step: "lost"})
+	req = httptest.NewRequest("PUT", "/steps/", bytes.NewBuffer(body))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code, "updating a missing unversioned row is a 404 too")
+}
+
+func TestRegisterResourceHooks(t *testing.T) {
+	store := newTestStore(t)
+	var createdID string
+	r := chi.NewRouter()
+	RegisterResource(r, store, "/users", ResourceOptions[types.User]{
+		Verbs: []Verb{VerbCreate},
+		Hooks: ResourceHooks[types.User]{
+			BeforeCreate: func(user *types.User) error {
+				if user.Email == "" {
+					return fmt.Errorf("%w: email is required", ErrRejectedByHook)
+				}
+				user.Status = "pending"
+				return nil
+			},
+			AfterCreate: func(_ types.User, id string) {
+				createdID = id
+			},
+		},
+	})
+
+	body, _ := json.Marshal(types.User{Name: "No Email"})
+	req := httptest.NewRequest("POST", "/users/", bytes.NewBuffer(body))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Empty(t, createdID)
+
+	body, _ = json.Marshal(types.User{Name: "Hooked", Email: "hooked@example.com", Status: "active"})
+	req = httptest.NewRequest("POST", "/users/", bytes.NewBuffer(body))
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.NotEmpty(t, createdID)
+	defer DeleteByType[types.User](store, createdID)
+
+	user, err := GetByType[types.User](store, createdID)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", user.Status)
+}
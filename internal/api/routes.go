@@ -3,16 +3,24 @@ package api
 import (
 	"net/http"
 	"opskrifter-backend/internal/middleware"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/httprate"
 )
 
-func RegisterRoutes(r *chi.Mux, env string) {
+// OperationTimeout bounds how long a request may spend on the database before
+// it is answered with 504.
+var OperationTimeout = 10 * time.Second
 
+func RegisterRoutes(r *chi.Mux, store myDB.Store, env string) {
+
+	r.Use(middleware.RequestID)
 	r.Use(middleware.ValidateJSONMiddleware)
 	r.Use(middleware.RejectSQLInjection)
+	r.Use(middleware.Timeout(OperationTimeout))
 
 	if env == "prod" {
 		r.Use(httprate.LimitByIP(20, 1*time.Minute))
@@ -23,23 +31,41 @@ func RegisterRoutes(r *chi.Mux, env string) {
 		w.Write([]byte("OK"))
 	})
 
-	setupRouter(r)
+	setupRouter(r, store)
 }
 
-func setupRouter(r *chi.Mux) {
-	r.Route("/recipes", func(r chi.Router) {
-		r.Post("/", CreateRecipe)
-		r.Get("/{id}", GetRecipe)
-		r.Get("/", GetManyRecipe)
-		r.Put("/", UpdateRecipe)
-		r.Delete("/{id}", DeleteRecipe)
-
-		r.Post("/{id}/like", LikeRecipe)
-		r.Delete("/{id}/like", UnlikeRecipe)
-		r.Post("/{id}/views", UpdateViewRecipe)
-	})
+func setupRouter(r *chi.Mux, store myDB.Store) {
+	// Set before mounting so that every subrouter answers with problems too.
+	r.NotFound(NotFoundHandler)
+	r.MethodNotAllowed(MethodNotAllowedHandler)
+
+	r.Route("/recipes", newRecipeResource(store).Mount)
 
+	ingredients := NewResource(store, ResourceOptions[types.Ingredient]{
+		Verbs: []Verb{VerbList, VerbGet},
+		List:  GetManyIngredients(store),
+	})
 	r.Route("/ingredients", func(r chi.Router) {
-		r.Get("/", GetManyIngredients)
+		ingredients.Mount(r)
+		r.Get("/{id}/family", IngredientFamilyHandler(store))
+
+		// The catalog is shared by every recipe, so only admins change it.
+		admin := r.With(middleware.AdminWrites)
+		admin.Post("/batch", ingredients.Batch)
+		admin.Put("/{id}/parent", SetIngredientParentHandler(store))
+		admin.Delete("/{id}/parent", RemoveIngredientParentHandler(store))
+	})
+
+	RegisterResource(r, store, "/ingredient-aliases", ResourceOptions[types.IngredientAlias]{
+		Verbs:      []Verb{VerbList, VerbGet, VerbCreate, VerbUpdate, VerbDelete},
+		Middleware: []func(http.Handler) http.Handler{middleware.AdminWrites},
+	})
+
+	RegisterResource(r, store, "/users", ResourceOptions[types.User]{
+		Verbs: []Verb{VerbGet, VerbCreate, VerbUpdate, VerbPatch, VerbDelete},
+	})
+
+	RegisterResource(r, store, "/steps", ResourceOptions[types.RecipeStep]{
+		Verbs: []Verb{VerbGet, VerbCreate, VerbUpdate, VerbPatch, VerbDelete},
 	})
 }
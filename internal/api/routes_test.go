@@ -17,12 +17,13 @@ import (
 )
 
 func TestRouteCreateRecipe(t *testing.T) {
+	store, router := newTestEnv(t)
 	body, _ := json.Marshal(testRecipe)
 	req := httptest.NewRequest("POST", "/recipes/", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp := httptest.NewRecorder()
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 	assert.Equal(t, http.StatusOK, resp.Code)
 
 	var response Response
@@ -31,19 +32,20 @@ func TestRouteCreateRecipe(t *testing.T) {
 
 	testRecipe.ID = response.ID
 
-	count, err := GetCountByType(testRecipe)
+	count, err := GetCountByType(store, testRecipe)
 	require.NoError(t, err, "unexpected error from GetCountByType")
 	assert.Equal(t, 1, count, "expected count to be 1")
-	_, err = DeleteByType[types.Recipe](response.ID)
+	_, err = DeleteByType[types.Recipe](store, response.ID)
 	require.NoError(t, err, "error deleting recipe")
 }
 
 func TestRouteUpdateRecipe(t *testing.T) {
+	store, router := newTestEnv(t)
 	body, _ := json.Marshal(testRecipe)
 	req := httptest.NewRequest("POST", "/recipes/", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 	resp := httptest.NewRecorder()
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 	require.Equal(t, http.StatusOK, resp.Code)
 
 	var createResp Response
@@ -58,19 +60,20 @@ func TestRouteUpdateRecipe(t *testing.T) {
 	req = httptest.NewRequest("PUT", "/recipes/", bytes.NewBuffer(updatedBody))
 	req.Header.Set("Content-Type", "application/json")
 	resp = httptest.NewRecorder()
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code)
-	_, err = DeleteByType[types.Recipe](createResp.ID)
+	_, err = DeleteByType[types.Recipe](store, createResp.ID)
 	require.NoError(t, err, "error deleting recipe")
 }
 
 func TestRouteDeleteRecipe(t *testing.T) {
+	_, router := newTestEnv(t)
 	body, _ := json.Marshal(testRecipe)
 	req := httptest.NewRequest("POST", "/recipes/", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 	resp := httptest.NewRecorder()
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
 	require.Equal(t, http.StatusOK, resp.Code)
 
@@ -83,23 +86,24 @@ func TestRouteDeleteRecipe(t *testing.T) {
 
 	req = httptest.NewRequest("DELETE", fmt.Sprintf("/recipes/%s", testRecipe.ID), nil)
 	resp = httptest.NewRecorder()
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code)
 
 	req = httptest.NewRequest("GET", fmt.Sprintf("/recipes/%s", testRecipe.ID), bytes.NewBuffer(updatedBody))
 	resp = httptest.NewRecorder()
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusNotFound, resp.Code)
 }
 
 func TestRouteGetRecipe(t *testing.T) {
+	_, router := newTestEnv(t)
 	body, _ := json.Marshal(testRecipe)
 	req := httptest.NewRequest("POST", "/recipes/", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 	resp := httptest.NewRecorder()
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
 	require.Equal(t, http.StatusOK, resp.Code)
 
@@ -112,48 +116,50 @@ func TestRouteGetRecipe(t *testing.T) {
 
 	req = httptest.NewRequest("GET", fmt.Sprintf("/recipes/%s", testRecipe.ID), bytes.NewBuffer(updatedBody))
 	resp = httptest.NewRecorder()
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 	assert.Equal(t, http.StatusOK, resp.Code)
 
 	req = httptest.NewRequest("DELETE", fmt.Sprintf("/recipes/%s", testRecipe.ID), nil)
 	resp = httptest.NewRecorder()
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code)
 }
 
 func TestRouteGetManyRecipe(t *testing.T) {
-	ids, err := CreateManyByType(testRecipes)
+	store, router := newTestEnv(t)
+	ids, err := CreateManyByType(store, testRecipes)
 	require.NoError(t, err, "error creating recipes")
 
 	defer func() {
-		err := DeleteManyByType[types.Recipe](ids)
+		err := DeleteManyByType[types.Recipe](store, ids)
 		require.NoError(t, err, "error deleting recipes")
 	}()
 
 	req := httptest.NewRequest("GET", "/recipes/?page=0&per_page=2&order_by=name", nil)
 	resp := httptest.NewRecorder()
 
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code, "expected status 200 OK")
 
-	var got []types.Recipe
+	var got ListResponse[types.Recipe]
 	err = json.NewDecoder(resp.Body).Decode(&got)
 	require.NoError(t, err, "error decoding response body")
 
-	require.Len(t, got, 2, "expected 2 recipes in result")
-	testutils.AssertSortedBy(t, got, func(a, b types.Recipe) bool {
+	require.Len(t, got.Items, 2, "expected 2 recipes in result")
+	testutils.AssertSortedBy(t, got.Items, func(a, b types.Recipe) bool {
 		return a.Name <= b.Name
 	})
 }
 
 func TestRouteLikeRecipe(t *testing.T) {
-	id, err := CreateByType(testRecipe)
+	store, router := newTestEnv(t)
+	id, err := CreateByType(store, testRecipe)
 	require.NoError(t, err, "error creating recipes")
 
 	defer func() {
-		_, err := DeleteByType[types.Recipe](id)
+		_, err := DeleteByType[types.Recipe](store, id)
 		require.NoError(t, err, "error deleting recipes")
 	}()
 
@@ -164,28 +170,29 @@ func TestRouteLikeRecipe(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	resp := httptest.NewRecorder()
 
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusCreated, resp.Code, "expected status 201")
-	updatedRecipe, err := GetByType[types.Recipe](id)
+	updatedRecipe, err := GetByType[types.Recipe](store, id)
 	require.NoError(t, err, "error getting the recipe")
 	assert.Equal(t, updatedRecipe.Likes, testRecipe.Likes+1)
-	r, err := GetRelationByType[types.UserLikedRecipe](adminUser.ID, id)
+	r, err := GetRelationByType[types.UserLikedRecipe](store, adminUser.ID, id)
 	require.NoError(t, err, "error getting the relation")
 	assert.Equal(t, r.RecipeID, id)
 	assert.Equal(t, r.UserID, adminUser.ID)
 
 	resp = httptest.NewRecorder()
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 	assert.Equal(t, http.StatusBadRequest, resp.Code)
 }
 
 func TestRouteUnLikeRecipe(t *testing.T) {
-	id, err := CreateByType(testRecipe)
+	store, router := newTestEnv(t)
+	id, err := CreateByType(store, testRecipe)
 	require.NoError(t, err, "error creating recipes")
 
 	defer func() {
-		_, err := DeleteByType[types.Recipe](id)
+		_, err := DeleteByType[types.Recipe](store, id)
 		require.NoError(t, err, "error deleting recipes")
 	}()
 
@@ -196,7 +203,7 @@ func TestRouteUnLikeRecipe(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	resp := httptest.NewRecorder()
 
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusCreated, resp.Code, "expected status 201")
 
@@ -204,23 +211,61 @@ func TestRouteUnLikeRecipe(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	resp = httptest.NewRecorder()
 
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
-	updatedRecipe, err := GetByType[types.Recipe](id)
+	updatedRecipe, err := GetByType[types.Recipe](store, id)
 	require.NoError(t, err, "error getting the recipe")
 	assert.Equal(t, updatedRecipe.Likes, testRecipe.Likes)
 
 	assert.Equal(t, http.StatusNoContent, resp.Code, "expected status 204")
-	_, err = GetRelationByType[types.UserLikedRecipe](adminUser.ID, id)
+	_, err = GetRelationByType[types.UserLikedRecipe](store, adminUser.ID, id)
 	require.ErrorIs(t, err, sql.ErrNoRows)
 }
 
+func TestRouteLikeRecipe_Concurrent(t *testing.T) {
+	store, router := newTestEnv(t)
+	id, err := CreateByType(store, testRecipe)
+	require.NoError(t, err, "error creating recipe")
+
+	const parallelRequests = 20
+	users := make([]string, parallelRequests)
+	for i := range users {
+		users[i], err = CreateByType(store, types.User{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@example.com", i)})
+		require.NoError(t, err, "error creating user")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(parallelRequests)
+	for _, userID := range users {
+		go func() {
+			defer wg.Done()
+			body, _ := json.Marshal(map[string]string{"user_id": userID})
+			req := httptest.NewRequest("POST", fmt.Sprintf("/recipes/%s/like", id), bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusCreated, resp.Code)
+		}()
+	}
+	wg.Wait()
+
+	for _, userID := range users {
+		_, err := GetRelationByType[types.UserLikedRecipe](store, userID, id)
+		assert.NoError(t, err, "every like belongs to the user who sent it")
+	}
+	updated, err := GetByType[types.Recipe](store, id)
+	require.NoError(t, err)
+	assert.Equal(t, testRecipe.Likes+parallelRequests, updated.Likes)
+}
+
 func TestRouteUpdateViewsRecipe_Concurrent(t *testing.T) {
-	id, err := CreateByType(testRecipe)
+	store, router := newTestEnv(t)
+	id, err := CreateByType(store, testRecipe)
 	require.NoError(t, err, "error creating recipe")
 
 	defer func() {
-		_, err := DeleteByType[types.Recipe](id)
+		_, err := DeleteByType[types.Recipe](store, id)
 		require.NoError(t, err, "error deleting recipe")
 	}()
 
@@ -234,7 +279,7 @@ func TestRouteUpdateViewsRecipe_Concurrent(t *testing.T) {
 			req := httptest.NewRequest("POST", fmt.Sprintf("/recipes/%s/views", id), nil)
 			req.Header.Set("Content-Type", "application/json")
 			resp := httptest.NewRecorder()
-			testRouter.ServeHTTP(resp, req)
+			router.ServeHTTP(resp, req)
 
 			assert.Equal(t, http.StatusOK, resp.Code)
 		}()
@@ -244,7 +289,7 @@ func TestRouteUpdateViewsRecipe_Concurrent(t *testing.T) {
 
 	req := httptest.NewRequest("GET", fmt.Sprintf("/recipes/%s", id), nil)
 	resp := httptest.NewRecorder()
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code)
 
@@ -256,18 +301,286 @@ func TestRouteUpdateViewsRecipe_Concurrent(t *testing.T) {
 }
 
 func TestGetAllIngredients(t *testing.T) {
+	store, router := newTestEnv(t)
 	req := httptest.NewRequest("GET", "/ingredients/", nil)
 	req.Header.Set("Content-Type", "application/json")
 	resp := httptest.NewRecorder()
 
-	testRouter.ServeHTTP(resp, req)
+	router.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code, "expected status 201")
 	var data []types.Ingredient
 	err := json.Unmarshal(resp.Body.Bytes(), &data)
 	require.NoError(t, err, "failed to decode response JSON")
-	count, err := GetCountByTable(data[0].TableName())
+	count, err := GetCountByTable(store, data[0].TableName())
 	require.NoError(t, err, "failed to get count response JSON")
 
 	assert.Equal(t, len(data), count, "expected ingredient to be the same ")
 }
+
+func TestRouteGetRecipeInclude(t *testing.T) {
+	store, router := newTestEnv(t)
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeSteps = testSteps[:3]
+	id, err := CreateByTypeWithRelations(store, recipe)
+	require.NoError(t, err, "error creating recipe")
+
+	defer func() {
+		_, err := DeleteByType[types.Recipe](store, id)
+		require.NoError(t, err, "error deleting recipe")
+	}()
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/recipes/%s", id), nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var got types.Recipe
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.Len(t, got.RecipeSteps, 3, "expected steps by default")
+	assert.NotNil(t, got.RecipeIngredients, "expected ingredients by default")
+
+	req = httptest.NewRequest("GET", fmt.Sprintf("/recipes/%s?include=ingredients", id), nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	got = types.Recipe{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.Nil(t, got.RecipeSteps, "expected steps to be left out")
+
+	req = httptest.NewRequest("GET", "/recipes/?page=1&per_page=5&include=steps", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var many ListResponse[types.Recipe]
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&many))
+	require.Len(t, many.Items, 1)
+	assert.Len(t, many.Items[0].RecipeSteps, 3)
+
+	req = httptest.NewRequest("GET", fmt.Sprintf("/recipes/%s?include=unknown", id), nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestRoutePatchRecipe(t *testing.T) {
+	store, router := newTestEnv(t)
+	recipe := recipeGenerator.Generate()
+	id, err := CreateByType(store, recipe)
+	require.NoError(t, err, "error creating recipe")
+
+	defer func() {
+		_, err := DeleteByType[types.Recipe](store, id)
+		require.NoError(t, err, "error deleting recipe")
+	}()
+
+	patch := func(contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/recipes/%s", id), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := patch(MergePatchContentType, `{"minutes": 25}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	got, err := GetByType[types.Recipe](store, id)
+	require.NoError(t, err)
+	assert.Equal(t, 25, got.Minutes)
+	assert.Equal(t, recipe.Name, got.Name, "untouched columns must keep their value")
+	assert.Equal(t, recipe.Description, got.Description)
+
+	resp = patch(JSONPatchContentType, `[{"op": "test", "path": "/minutes", "value": 25}, {"op": "replace", "path": "/name", "value": "Patched"}]`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	got, err = GetByType[types.Recipe](store, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Patched", got.Name)
+	assert.Equal(t, 25, got.Minutes)
+
+	resp = patch(JSONPatchContentType, `[{"op": "test", "path": "/minutes", "value": 1}]`)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	resp = patch(MergePatchContentType, `{"minutes": "soon"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = patch(MergePatchContentType, `{"id": "other"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = patch(MergePatchContentType, `{"unknown": 1}`)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = patch("application/json", `{"minutes": 1}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+
+	req := httptest.NewRequest("PATCH", "/recipes/does-not-exist", bytes.NewBufferString(`{"minutes": 1}`))
+	req.Header.Set("Content-Type", MergePatchContentType)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestRouteGetManyRecipeFiltered(t *testing.T) {
+	store, router := newTestEnv(t)
+	recipes := recipeGenerator.GenerateMany(4)
+	for i := range recipes {
+		recipes[i].Minutes = 10 * (i + 1)
+		recipes[i].RecipeCuisine = "dansk"
+	}
+	recipes[3].RecipeCuisine = "italiensk"
+
+	ids, err := CreateManyByType(store, recipes)
+	require.NoError(t, err, "error creating recipes")
+
+	defer func() {
+		require.NoError(t, DeleteManyByType[types.Recipe](store, ids), "error deleting recipes")
+	}()
+
+	get := func(query string) (*httptest.ResponseRecorder, []types.Recipe) {
+		req := httptest.NewRequest("GET", "/recipes/?page=1&per_page=10&"+query, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		var got ListResponse[types.Recipe]
+		if resp.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+		}
+		return resp, got.Items
+	}
+
+	resp, got := get("minutes[lte]=20")
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Len(t, got, 2)
+
+	resp, got = get("recipe_cuisine=dansk&minutes[gt]=10")
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Len(t, got, 2)
+
+	resp, got = get("recipe_cuisine[in]=italiensk,fransk")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Len(t, got, 1)
+	assert.Equal(t, ids[3], got[0].ID)
+
+	resp, _ = get("description=secret")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp, _ = get("minutes[like]=1")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestRouteGetManyRecipeCursor(t *testing.T) {
+	store, router := newTestEnv(t)
+	recipes := recipeGenerator.GenerateMany(7)
+	for i := range recipes {
+		recipes[i].Minutes = 10*(i%3) + 1
+	}
+
+	ids, err := CreateManyByType(store, recipes)
+	require.NoError(t, err, "error creating recipes")
+
+	defer func() {
+		require.NoError(t, DeleteManyByType[types.Recipe](store, ids), "error deleting recipes")
+	}()
+
+	seen := map[string]bool{}
+	var all []types.Recipe
+	cursor := ""
+	for range 10 {
+		req := httptest.NewRequest("GET", "/recipes/?per_page=3&order_by=minutes&cursor="+cursor, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+		var page ListResponse[types.Recipe]
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+
+		for _, r := range page.Items {
+			require.False(t, seen[r.ID], "recipe returned twice")
+			seen[r.ID] = true
+		}
+		all = append(all, page.Items...)
+
+		if len(all) == 3 {
+			// Rows inserted before the cursor must not shift later pages.
+			early := recipeGenerator.Generate()
+			early.Minutes = 0
+			id, err := CreateByType(store, early)
+			require.NoError(t, err)
+			ids = append(ids, id)
+		}
+
+		require.Equal(t, page.NextCursor != "", page.HasMore)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	require.Len(t, all, len(recipes))
+	testutils.AssertSortedBy(t, all, func(a, b types.Recipe) bool {
+		return a.Minutes < b.Minutes || (a.Minutes == b.Minutes && a.ID < b.ID)
+	})
+
+	req := httptest.NewRequest("GET", "/recipes/?per_page=3&cursor=garbage", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestRouteSparseFieldsets(t *testing.T) {
+	store, router := newTestEnv(t)
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeSteps = testSteps[:2]
+	id, err := CreateByTypeWithRelations(store, recipe)
+	require.NoError(t, err, "error creating recipe")
+
+	defer func() {
+		_, err := DeleteByType[types.Recipe](store, id)
+		require.NoError(t, err, "error deleting recipe")
+	}()
+
+	get := func(url string) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest("GET", url, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		var body map[string]any
+		if resp.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		}
+		return resp, body
+	}
+
+	resp, body := get("/recipes/?fields=id,name,image,likes")
+	require.Equal(t, http.StatusOK, resp.Code)
+	items := body["items"].([]any)
+	require.Len(t, items, 1)
+	assert.Equal(t, map[string]any{
+		"id":    id,
+		"name":  recipe.Name,
+		"image": recipe.Image,
+		"likes": float64(recipe.Likes),
+	}, items[0])
+
+	resp, body = get(fmt.Sprintf("/recipes/%s?fields=name&include=steps", id))
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Len(t, body, 2)
+	assert.Equal(t, recipe.Name, body["name"])
+	assert.Len(t, body["RecipeSteps"], 2)
+
+	resp, _ = get("/recipes/?fields=name,password")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	req := httptest.NewRequest("GET", "/ingredients/?fields=name", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var ingredients []map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ingredients))
+	require.NotEmpty(t, ingredients)
+	assert.Equal(t, map[string]any{"name": "A38"}, ingredients[0])
+}
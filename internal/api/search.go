@@ -0,0 +1,355 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+var ErrSearchUnavailable = errors.New("full-text search is not available in this build")
+var ErrEmptySearch = errors.New("search needs at least one word")
+
+// Snippets mark the matched words with these.
+var (
+	SnippetStart = "<mark>"
+	SnippetEnd   = "</mark>"
+)
+
+// SQLite marks the matches with private use characters, which survive HTML
+// escaping and are swapped for SnippetStart and SnippetEnd afterwards.
+const (
+	snippetOpen  = "\uE000"
+	snippetClose = "\uE001"
+)
+
+// To unicode61 Å is an A with a ring, which remove_diacritics folds to a, so
+// "bal" would match "bål". The index holds å and Å as private use characters
+// instead, which unicode61 keeps as letters of their own, and snippets get
+// them back.
+const (
+	indexedAA      = "\uE002"
+	indexedUpperAA = "\uE003"
+)
+
+var snippetMarks = strings.NewReplacer(snippetOpen, SnippetStart, snippetClose, SnippetEnd, indexedAA, "å", indexedUpperAA, "Å")
+
+// snippetHTML escapes the recipe text of a raw snippet, so only its marks
+// are markup and its letters are the recipe's.
+func snippetHTML(raw string) string {
+	return snippetMarks.Replace(html.EscapeString(raw))
+}
+
+// MaxSearchTerms bounds how many words of a search are matched.
+const MaxSearchTerms = 16
+
+// recipeSearchIndex is the FTS5 table recipes are searched in. Its rows are
+// kept in sync with recipes and recipe_steps by triggers. unicode61 folds
+// case, including Æ and Ø, and remove_diacritics 2 lets "creme" match
+// "crème". Æ and Ø are letters of their own and are kept, and so is Å, see
+// indexedAA.
+const recipeSearchIndex = `CREATE VIRTUAL TABLE IF NOT EXISTS recipe_search USING fts5(
+	recipe_id UNINDEXED,
+	name,
+	description,
+	steps,
+	tokenize = 'unicode61 remove_diacritics 2'
+)`
+
+// searchRows selects the rows of the search index for the recipes matching
+// where, with å and Å spelled the way the index holds them.
+func searchRows(where string) string {
+	indexed := func(text string) string {
+		return fmt.Sprintf("REPLACE(REPLACE(%s, 'å', '%s'), 'Å', '%s')", text, indexedAA, indexedUpperAA)
+	}
+	return fmt.Sprintf(`INSERT INTO recipe_search (recipe_id, name, description, steps)
+	SELECT id, %s, %s, %s FROM recipes%s`,
+		indexed("name"), indexed("description"),
+		indexed("COALESCE((SELECT group_concat(step, ' ') FROM recipe_steps WHERE recipe_id = recipes.id), '')"),
+		where)
+}
+
+// refreshSearchRow rewrites the row of the recipe with the given id, or
+// drops it when the recipe is gone.
+func refreshSearchRow(id string) string {
+	return fmt.Sprintf(`DELETE FROM recipe_search WHERE recipe_id = %[1]s;
+	%[2]s;`, id, searchRows(" WHERE id = "+id))
+}
+
+var recipeSearchTriggerNames = []string{
+	"recipe_search_insert", "recipe_search_update", "recipe_search_delete",
+	"recipe_search_step_insert", "recipe_search_step_update", "recipe_search_step_delete",
+}
+
+var recipeSearchTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS recipe_search_insert AFTER INSERT ON recipes BEGIN ` + refreshSearchRow("NEW.id") + ` END`,
+	`CREATE TRIGGER IF NOT EXISTS recipe_search_update AFTER UPDATE OF name, description ON recipes BEGIN ` + refreshSearchRow("NEW.id") + ` END`,
+	`CREATE TRIGGER IF NOT EXISTS recipe_search_delete AFTER DELETE ON recipes BEGIN DELETE FROM recipe_search WHERE recipe_id = OLD.id; END`,
+	`CREATE TRIGGER IF NOT EXISTS recipe_search_step_insert AFTER INSERT ON recipe_steps BEGIN ` + refreshSearchRow("NEW.recipe_id") + ` END`,
+	`CREATE TRIGGER IF NOT EXISTS recipe_search_step_update AFTER UPDATE ON recipe_steps BEGIN ` + refreshSearchRow("OLD.recipe_id") + refreshSearchRow("NEW.recipe_id") + ` END`,
+	`CREATE TRIGGER IF NOT EXISTS recipe_search_step_delete AFTER DELETE ON recipe_steps BEGIN ` + refreshSearchRow("OLD.recipe_id") + ` END`,
+}
+
+// SearchAvailable reports whether q can run FTS5 queries. go-sqlite3 only
+// has FTS5 when built with -tags sqlite_fts5.
+func SearchAvailable(ctx context.Context, q myDB.Querier) bool {
+	if dialectOf(q) != SQLite {
+		return false
+	}
+
+	var used bool
+	err := q.GetContext(ctx, &used, "SELECT sqlite_compileoption_used('ENABLE_FTS5')")
+	return err == nil && used
+}
+
+// EnsureRecipeSearch creates the search index and its triggers when they are
+// missing. A new index is filled from the recipes; an existing one is kept up
+// to date by the triggers and left alone, unless its triggers still fill it
+// with å and Å as they are, in which case it is filled again. Since FTS5
+// depends on the build, this runs at startup instead of as a migration.
+func EnsureRecipeSearch(ctx context.Context, q myDB.Querier) error {
+	if !SearchAvailable(ctx, q) {
+		return ErrSearchUnavailable
+	}
+
+	return myDB.WithTxContext(ctx, q, func(tx myDB.Querier) error {
+		var exists bool
+		err := tx.GetContext(ctx, &exists, "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'recipe_search'")
+		if err != nil {
+			return fmt.Errorf("failed to look for the recipe search index: %w", err)
+		}
+
+		var current bool
+		err = tx.GetContext(ctx, &current, "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'trigger' AND name = 'recipe_search_insert' AND instr(sql, ?) > 0", indexedAA)
+		if err != nil {
+			return fmt.Errorf("failed to look for the recipe search triggers: %w", err)
+		}
+
+		statements := recipeSearchTriggers
+		switch {
+		case !exists:
+			statements = append([]string{recipeSearchIndex}, recipeSearchTriggers...)
+			statements = append(statements, searchRows(""))
+		case !current:
+			statements = nil
+			for _, trigger := range recipeSearchTriggerNames {
+				statements = append(statements, "DROP TRIGGER IF EXISTS "+trigger)
+			}
+			statements = append(statements, recipeSearchTriggers...)
+			statements = append(statements, "DELETE FROM recipe_search", searchRows(""))
+		}
+
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("failed to set up recipe search: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// RecipeSearchHit is a recipe matching a search, with the best matching part
+// of its text and its bm25 rank. Lower ranks are better. The snippet is HTML:
+// the text is escaped and the matches are wrapped in SnippetStart and
+// SnippetEnd.
+type RecipeSearchHit struct {
+	types.Recipe
+	Snippet string  `json:"snippet" db:"snippet"`
+	Rank    float64 `json:"rank" db:"rank"`
+}
+
+// danishLetters spells out æ, ø and å the way they are written on keyboards
+// without them.
+var danishLetters = strings.NewReplacer("ae", "æ", "oe", "ø", "aa", "å")
+
+// searchMatch turns the words of text into an FTS5 query that matches rows
+// holding every word, each as a prefix. A word written with ae, oe or aa also
+// matches the Danish spelling, so "floede" finds "fløde", and å is matched
+// the way the index holds it. Anything but letters and digits separates
+// words, so the query syntax of FTS5 never reaches the index.
+func searchMatch(text string) (string, error) {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "", ErrEmptySearch
+	}
+	if len(words) > MaxSearchTerms {
+		words = words[:MaxSearchTerms]
+	}
+
+	terms := make([]string, len(words))
+	for i, word := range words {
+		word = strings.ToLower(word)
+		spellings := indexedSpellings(word)
+		if danish := danishLetters.Replace(word); danish != word {
+			spellings = append(spellings, indexedSpellings(danish)...)
+		}
+
+		for j, spelling := range spellings {
+			spellings[j] = `"` + spelling + `"*`
+		}
+		terms[i] = strings.Join(spellings, " OR ")
+		if len(spellings) > 1 {
+			terms[i] = "(" + terms[i] + ")"
+		}
+	}
+	return strings.Join(terms, " "), nil
+}
+
+// indexedSpellings returns how the lower case word can be held by the index,
+// whose case folding leaves the private use letters standing for å and Å
+// alone: with a small å, with a capital one first, as in "Ål", and with
+// capital ones only, as in "BLÅBÆR".
+func indexedSpellings(word string) []string {
+	if !strings.Contains(word, "å") {
+		return []string{word}
+	}
+
+	lower := strings.ReplaceAll(word, "å", indexedAA)
+	spellings := []string{lower}
+	if rest, ok := strings.CutPrefix(word, "å"); ok {
+		spellings = append(spellings, indexedUpperAA+strings.ReplaceAll(rest, "å", indexedAA))
+	}
+	if upper := strings.ReplaceAll(word, "å", indexedUpperAA); !slices.Contains(spellings, upper) {
+		spellings = append(spellings, upper)
+	}
+	return spellings
+}
+
+// searchFrom joins the live recipes with their matches for text and returns
+// the conditions and arguments narrowing them down to opts. Recipes using one
+// of ingredients, or an ingredient below them, match too, ranked after every
+// match of the text since bm25 ranks are negative.
+func searchFrom(d Dialect, text string, ingredients []string, opts QueryOptions) (string, []string, []any, error) {
+	match, err := searchMatch(text)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	t := reflect.TypeOf(types.Recipe{})
+	args := []any{snippetOpen, snippetClose, match}
+	hits := fmt.Sprintf(`SELECT recipe_id,
+		snippet(recipe_search, -1, %s, %s, '…', 16) AS snippet,
+		bm25(recipe_search, 0.0, 10.0, 4.0, 1.0) AS rank
+		FROM recipe_search WHERE recipe_search MATCH %s`,
+		d.Placeholder(1), d.Placeholder(2), d.Placeholder(3))
+
+	if len(ingredients) > 0 {
+		args = append(args, snippetOpen, snippetClose)
+		for _, id := range ingredients {
+			args = append(args, id)
+		}
+		// SQLite takes the snippet of the row with the lowest rank.
+		hits = fmt.Sprintf(`SELECT recipe_id, snippet, MIN(rank) AS rank FROM (%[1]s
+			UNION ALL SELECT uses.%[2]s, %[3]s || %[4]s.%[5]s || %[6]s, 0.0
+			FROM %[7]s AS uses JOIN %[4]s ON %[4]s.%[8]s = uses.%[9]s
+			WHERE uses.%[9]s IN (%[10]s)) AS found GROUP BY recipe_id`,
+			hits, d.Quote("recipe_id"), d.Placeholder(4), d.Quote("ingredients"), d.Quote("name"), d.Placeholder(5),
+			d.Quote("ingredients_for_recipe"), d.Quote("id"), d.Quote("ingredient_id"),
+			ingredientsBelow(d, placeholders(d, 5, len(ingredients))))
+	}
+
+	from := fmt.Sprintf("%[1]s JOIN (%[2]s) AS hits ON hits.recipe_id = %[1]s.%[3]s", d.Quote("recipes"), hits, d.Quote("id"))
+
+	conditions, filterArgs, err := buildFilterConditions(d, t, opts.Filters, len(args))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	args = append(args, filterArgs...)
+	used, usedArgs, err := ingredientConditions(d, t, "recipes", opts, len(args))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	conditions, args = append(conditions, used...), append(args, usedArgs...)
+	conditions = append(conditions, liveCondition(d, t, false)...)
+
+	return from, conditions, args, nil
+}
+
+// BuildSearchQuery finds the recipes matching text or using one of
+// ingredients, best first, narrowed by the filters of opts and paged by its
+// page and per_page.
+func BuildSearchQuery(d Dialect, text string, ingredients []string, opts QueryOptions) (string, []any, error) {
+	if opts.UseCursor {
+		return "", nil, fmt.Errorf("%w: search results are paged with page", ErrInvalidCursor)
+	}
+
+	from, conditions, args, err := searchFrom(d, text, ingredients, opts)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s.*, hits.snippet, hits.rank FROM %s", d.Quote("recipes"), from) +
+		whereClause(conditions) +
+		fmt.Sprintf(" ORDER BY hits.rank, %s.%s LIMIT %s OFFSET %s", d.Quote("recipes"), d.Quote("id"),
+			d.Placeholder(len(args)+1), d.Placeholder(len(args)+2))
+	args = append(args, opts.PerPage, (opts.Page-1)*opts.PerPage)
+
+	return query, args, nil
+}
+
+// BuildSearchCountQuery counts the recipes BuildSearchQuery pages through.
+func BuildSearchCountQuery(d Dialect, text string, ingredients []string, opts QueryOptions) (string, []any, error) {
+	from, conditions, args, err := searchFrom(d, text, ingredients, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return "SELECT COUNT(*) FROM " + from + whereClause(conditions), args, nil
+}
+
+func SearchRecipes(q myDB.Querier, text string, opts QueryOptions) ([]RecipeSearchHit, error) {
+	return SearchRecipesContext(context.Background(), q, text, opts)
+}
+
+// SearchRecipesContext returns a page of the recipes matching text. When
+// text names an ingredient, by its name or an alias, the recipes using it or
+// an ingredient below it match too, so "ost" finds every cheese dish.
+func SearchRecipesContext(ctx context.Context, q myDB.Querier, text string, opts QueryOptions) ([]RecipeSearchHit, error) {
+	ingredients, err := IngredientsNamedContext(ctx, q, text)
+	if err != nil {
+		return nil, err
+	}
+
+	query, args, err := BuildSearchQuery(dialectOf(q), text, ingredients, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []RecipeSearchHit
+	if err := q.SelectContext(ctx, &hits, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to search recipes: %w", err)
+	}
+	for i := range hits {
+		hits[i].Snippet = snippetHTML(hits[i].Snippet)
+	}
+	return hits, nil
+}
+
+func CountSearchRecipes(q myDB.Querier, text string, opts QueryOptions) (int, error) {
+	return CountSearchRecipesContext(context.Background(), q, text, opts)
+}
+
+func CountSearchRecipesContext(ctx context.Context, q myDB.Querier, text string, opts QueryOptions) (int, error) {
+	ingredients, err := IngredientsNamedContext(ctx, q, text)
+	if err != nil {
+		return 0, err
+	}
+
+	query, args, err := BuildSearchCountQuery(dialectOf(q), text, ingredients, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int
+	if err := q.GetContext(ctx, &total, query, args...); err != nil {
+		return 0, fmt.Errorf("failed to count search results: %w", err)
+	}
+	return total, nil
+}
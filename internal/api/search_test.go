@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchMatch(t *testing.T) {
+	match, err := searchMatch(`Rødgrød "med" fløde OR -NEAR(x*`)
+	require.NoError(t, err)
+	assert.Equal(t, `"rødgrød"* "med"* "fløde"* "or"* "near"* "x"*`, match)
+
+	match, err = searchMatch("Blaabaer")
+	require.NoError(t, err)
+	assert.Equal(t, `("blaabaer"* OR "bl`+indexedAA+`bær"* OR "bl`+indexedUpperAA+`bær"*)`, match)
+
+	match, err = searchMatch("Ål")
+	require.NoError(t, err)
+	assert.Equal(t, `("`+indexedAA+`l"* OR "`+indexedUpperAA+`l"*)`, match)
+
+	_, err = searchMatch(` "*" - `)
+	assert.ErrorIs(t, err, ErrEmptySearch)
+}
+
+// newSearchStore returns a store with a search index, skipping the test when
+// the build has no FTS5 (go test -tags sqlite_fts5 has it).
+func newSearchStore(t *testing.T) myDB.Store {
+	store := newTestStore(t)
+	if !SearchAvailable(t.Context(), store) {
+		t.Skip("built without FTS5, run with -tags sqlite_fts5")
+	}
+	require.NoError(t, EnsureRecipeSearch(t.Context(), store))
+	return store
+}
+
+func TestSearchRecipes(t *testing.T) {
+	store := newSearchStore(t)
+
+	create := func(name string, description string, steps ...string) string {
+		recipe := recipeGenerator.Generate()
+		recipe.Name, recipe.Description = name, description
+		for _, step := range steps {
+			recipe.RecipeSteps = append(recipe.RecipeSteps, types.RecipeStep{Step: step})
+		}
+		id, err := CreateByTypeWithRelations(store, recipe)
+		require.NoError(t, err)
+		return id
+	}
+
+	grod := create("Rødgrød med fløde", "Sommerens dessert", "Kog bærrene", "Server med kold fløde")
+	kage := create("Æblekage", "Lagdelt med flødeskum", "Rist raspen")
+	creme := create("Crème brûlée", "Fransk klassiker", "Karamellisér sukkeret")
+	trashed := create("Fløde og jordbær", "", "Pisk")
+
+	_, err := DeleteByType[types.Recipe](store, trashed)
+	require.NoError(t, err)
+
+	search := func(text string) []RecipeSearchHit {
+		hits, err := SearchRecipes(store, text, QueryOptions{Page: 1, PerPage: 10})
+		require.NoError(t, err)
+		return hits
+	}
+	ids := func(hits []RecipeSearchHit) []string {
+		var out []string
+		for _, hit := range hits {
+			out = append(out, hit.ID)
+		}
+		return out
+	}
+
+	hits := search("fløde")
+	assert.Equal(t, []string{grod, kage}, ids(hits), "a match in the name ranks first, trashed recipes are left out")
+	assert.Contains(t, hits[0].Snippet, SnippetStart+"fløde"+SnippetEnd)
+
+	assert.Equal(t, []string{grod, kage}, ids(search("FLØDE")), "case is folded")
+	assert.Equal(t, []string{grod, kage}, ids(search("floede")), "oe spells ø")
+	assert.Equal(t, []string{kage}, ids(search("æble")), "words match as prefixes")
+	assert.Equal(t, []string{creme}, ids(search("creme brulee")))
+	assert.Equal(t, []string{grod}, ids(search("kold bær")), "step text is searched")
+	assert.Empty(t, search("fløde karamel"), "every word has to match")
+
+	total, err := CountSearchRecipes(store, "fløde", QueryOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+
+	withIngredient := QueryOptions{Page: 1, PerPage: 10, RequireIngredients: []string{"none"}}
+	hits, err = SearchRecipes(store, "fløde", withIngredient)
+	require.NoError(t, err)
+	assert.Empty(t, hits, "ingredient filters narrow the search")
+	total, err = CountSearchRecipes(store, "fløde", withIngredient)
+	require.NoError(t, err)
+	assert.Zero(t, total)
+
+	_, err = PatchByType[types.Recipe](store, creme, MergePatch{"name": "Brændt fløde"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{grod, kage, creme}, ids(search("fløde")), "updates reach the index")
+
+	_, err = RestoreByType[types.Recipe](store, trashed)
+	require.NoError(t, err)
+	assert.Len(t, search("jordbær"), 1)
+
+	soup := create(`<script>alert("snegl")</script> Ålesuppe`, "")
+	hits = search("snegl")
+	require.Len(t, hits, 1)
+	assert.NotContains(t, hits[0].Snippet, "<script>", "recipe text is escaped")
+	assert.Contains(t, hits[0].Snippet, "&lt;script&gt;alert(&#34;"+SnippetStart+"snegl"+SnippetEnd)
+
+	assert.Equal(t, []string{soup}, ids(search("aalesuppe")), "aa spells å")
+	assert.Equal(t, []string{soup}, ids(search("ålesuppe")), "Å is folded to å")
+	assert.Empty(t, search("alesuppe"), "å is not an accented a")
+
+	bonfire := create("Snobrød over bål", "", "Tænd bålet")
+	plain := create("Bal", "Til festen")
+	assert.Equal(t, []string{bonfire}, ids(search("bål")))
+	assert.Equal(t, []string{bonfire}, ids(search("BÅL")))
+	assert.Equal(t, []string{plain}, ids(search("bal")), "bål does not match bal")
+	hits = search("bål")
+	require.Len(t, hits, 1)
+	assert.Contains(t, hits[0].Snippet, SnippetStart+"bål"+SnippetEnd, "snippets show å")
+
+	_, err = store.ExecContext(t.Context(), "DELETE FROM recipe_search")
+	require.NoError(t, err)
+	require.NoError(t, EnsureRecipeSearch(t.Context(), store))
+	assert.Empty(t, search("fløde"), "an existing index is not rebuilt")
+}
+
+func TestEnsureRecipeSearchRefillsFoldedIndex(t *testing.T) {
+	store := newSearchStore(t)
+	recipe := recipeGenerator.Generate()
+	recipe.Name = "Bål"
+	id, err := CreateByType(store, recipe)
+	require.NoError(t, err)
+
+	// An index set up before å was kept apart holds it as it is.
+	_, err = store.ExecContext(t.Context(), `DROP TRIGGER recipe_search_insert;
+		CREATE TRIGGER recipe_search_insert AFTER INSERT ON recipes BEGIN SELECT 1; END;
+		UPDATE recipe_search SET name = 'Bål'`)
+	require.NoError(t, err)
+
+	require.NoError(t, EnsureRecipeSearch(t.Context(), store))
+	hits, err := SearchRecipes(store, "bål", QueryOptions{Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	assert.Equal(t, id, hits[0].ID)
+
+	hits, err = SearchRecipes(store, "bal", QueryOptions{Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	assert.Empty(t, hits)
+}
+
+func TestRouteSearchRecipes(t *testing.T) {
+	store, router := newTestEnv(t)
+	recipe := recipeGenerator.Generate()
+	recipe.Name = "Hindbærsnitter"
+	_, err := CreateByType(store, recipe)
+	require.NoError(t, err)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/recipes/search?"+query, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if !SearchAvailable(t.Context(), store) {
+		assert.Equal(t, http.StatusNotImplemented, get("q=hindbær").Code)
+		return
+	}
+
+	resp := get("q=" + url.QueryEscape("hindbær") + "&per_page=5")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	var got ListResponse[RecipeSearchHit]
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	require.Len(t, got.Items, 1)
+	assert.Equal(t, "Hindbærsnitter", got.Items[0].Name)
+	assert.Equal(t, 1, got.Total)
+
+	assert.Equal(t, http.StatusBadRequest, get("q=%20").Code)
+	assert.Equal(t, http.StatusBadRequest, get("q=hindbær&cursor=").Code)
+	assert.Equal(t, http.StatusOK, get("q=hindbær&minutes[gte]=0").Code)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Fields can be owned by the server instead of the client:
+//
+//   - readonly:"true" fields, such as counters, are never written from a
+//     request. Inserts leave them to the column default and updates skip
+//     them; only dedicated queries like UpdateCountByType change them.
+//   - server:"now" fields get the current time on insert and are never
+//     updated.
+//   - immutable:"true" fields are set on insert, like the owner of a row,
+//     and are never updated.
+//   - fold:"column" fields hold column folded by normalizeName, so a name
+//     can be looked up ignoring case and accents through an index. Every
+//     insert and update of the row sets them.
+
+// isReadOnly reports whether field is never written from client input.
+func isReadOnly(field reflect.StructField) bool {
+	return field.Tag.Get("readonly") == "true"
+}
+
+// serverValues are the values a server tag can ask for.
+var serverValues = map[string]func() any{
+	"now": func() any { return time.Now().UTC().Format(time.RFC3339) },
+}
+
+// checkServerTag reports a server tag serverValue does not know.
+func checkServerTag(tag string) error {
+	if _, ok := serverValues[tag]; !ok {
+		return fmt.Errorf("unknown value %q", tag)
+	}
+	return nil
+}
+
+// serverValue returns the value the server stores in field on insert, and
+// whether field has one. An unknown server tag is an ErrInvalidTag.
+func serverValue(field reflect.StructField) (any, bool, error) {
+	tag := field.Tag.Get("server")
+	if tag == "" {
+		return nil, false, nil
+	}
+	if err := checkServerTag(tag); err != nil {
+		return nil, false, fmt.Errorf("%w: %s: server: %v", ErrInvalidTag, field.Name, err)
+	}
+	return serverValues[tag](), true, nil
+}
+
+// checkFoldTag reports a fold tag naming no string column of t.
+func checkFoldTag(t reflect.Type, column string) error {
+	if source, ok := fieldByColumn(t, column); !ok || source.Type.Kind() != reflect.String {
+		return fmt.Errorf("no string column %q", column)
+	}
+	return nil
+}
+
+// foldedValue returns the value the server stores in field of v on every
+// write, and whether field has one. A fold tag naming no string column of v
+// is an ErrInvalidTag.
+func foldedValue(v reflect.Value, field reflect.StructField) (string, bool, error) {
+	column := field.Tag.Get("fold")
+	if column == "" {
+		return "", false, nil
+	}
+	if err := checkFoldTag(v.Type(), column); err != nil {
+		return "", false, fmt.Errorf("%w: %s: fold: %v", ErrInvalidTag, field.Name, err)
+	}
+	source, _ := fieldByColumn(v.Type(), column)
+	return normalizeName(v.FieldByIndex(source.Index).String()), true, nil
+}
+
+// foldedColumn returns the fold:"column" column of the model stored in
+// table, when it has one.
+func foldedColumn(table string, column string) (string, bool) {
+	for _, model := range Models {
+		if model.TableName() != table {
+			continue
+		}
+		t := reflect.TypeOf(model)
+		for i := range t.NumField() {
+			if t.Field(i).Tag.Get("fold") == column {
+				return columnTag(t.Field(i))
+			}
+		}
+	}
+	return "", false
+}
+
+// isUpdatable reports whether the column of field may change after the row
+// is inserted.
+func isUpdatable(field reflect.StructField) bool {
+	immutable := field.Tag.Get("immutable") == "true"
+	server := field.Tag.Get("server") != "" || field.Tag.Get("fold") != ""
+	return !immutable && !server && !isReadOnly(field)
+}
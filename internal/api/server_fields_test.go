@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opskrifter-backend/internal/types"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerOwnedFields(t *testing.T) {
+	store := newTestStore(t)
+	recipe := recipeGenerator.Generate()
+	recipe.Likes = 9999
+	recipe.Views = 9999
+	recipe.CreatedAt = "1999-01-01T00:00:00Z"
+
+	id, err := CreateByType(store, recipe)
+	require.NoError(t, err)
+
+	stored, err := GetByType[types.Recipe](store, id)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stored.Likes)
+	assert.Equal(t, 0, stored.Views)
+	createdAt, err := time.Parse(time.RFC3339, stored.CreatedAt)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), createdAt, time.Minute)
+
+	require.NoError(t, UpdateCountByType(store, stored, "likes", "+1"))
+
+	stored.Likes = 9999
+	stored.UserID = "someone-else"
+	stored.CreatedAt = "1999-01-01T00:00:00Z"
+	stored.Name = "Renamed"
+	_, err = UpdateByType(store, stored)
+	require.NoError(t, err)
+
+	updated, err := GetByType[types.Recipe](store, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed", updated.Name)
+	assert.Equal(t, 1, updated.Likes, "counters only move through their own queries")
+	assert.Equal(t, adminUser.ID, updated.UserID)
+	assert.Equal(t, createdAt.Format(time.RFC3339), updated.CreatedAt)
+
+	for _, patch := range []MergePatch{{"likes": 5}, {"user_id": "someone-else"}, {"created_at": "now"}} {
+		_, err = PatchByType[types.Recipe](store, id, patch)
+		assert.ErrorIs(t, err, ErrInvalidPatch)
+	}
+}
+
+func TestRouteIgnoresReadOnlyFields(t *testing.T) {
+	store, router := newTestEnv(t)
+	recipe := recipeGenerator.Generate()
+	recipe.Likes = 9999
+	body, _ := json.Marshal(recipe)
+
+	req := httptest.NewRequest("POST", "/recipes/", bytes.NewBuffer(body))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var created Response
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	stored, err := GetByType[types.Recipe](store, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stored.Likes)
+}
+
+func TestServerTagValues(t *testing.T) {
+	type row struct {
+		Counted int `readonly:"true" immutable:"true"`
+		Written int `readonly:"false" immutable:"false"`
+	}
+	counted, _ := reflect.TypeOf(row{}).FieldByName("Counted")
+	written, _ := reflect.TypeOf(row{}).FieldByName("Written")
+	assert.True(t, isReadOnly(counted))
+	assert.False(t, isReadOnly(written), `readonly:"false" leaves the field writable`)
+	assert.False(t, isUpdatable(counted))
+	assert.True(t, isUpdatable(written))
+}
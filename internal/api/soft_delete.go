@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"reflect"
+	"time"
+)
+
+// TrashRetention is how long deleted recipes stay in the trash before
+// PurgeTrash removes them for good.
+var TrashRetention = 30 * 24 * time.Hour
+
+// softDeleteColumn returns the column of the field of t tagged
+// soft_delete:"true". Deleting such types only stamps the column, and the
+// generic reads skip stamped rows until they are restored or purged.
+func softDeleteColumn(t reflect.Type) (string, bool) {
+	if t.Kind() != reflect.Struct {
+		return "", false
+	}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if _, ok := field.Tag.Lookup("soft_delete"); ok {
+			return columnTag(field)
+		}
+	}
+	return "", false
+}
+
+// liveCondition is the condition keeping the rows of t that are in the
+// trash, or those that are not, or nothing when t is never soft deleted.
+func liveCondition(d Dialect, t reflect.Type, trashed bool) []string {
+	col, ok := softDeleteColumn(t)
+	if !ok {
+		return nil
+	}
+
+	if trashed {
+		return []string{d.Quote(col) + " IS NOT NULL"}
+	}
+	return []string{d.Quote(col) + " IS NULL"}
+}
+
+// andLive narrows a query already holding a WHERE clause to live rows.
+func andLive(d Dialect, t reflect.Type) string {
+	conditions := liveCondition(d, t, false)
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " AND " + conditions[0]
+}
+
+// deletedAt is the timestamp written to the soft delete column. The fixed
+// width UTC format keeps the text ordered by time.
+func deletedAt(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// RestoreByType takes the row with id out of the trash.
+func RestoreByType[T types.Identifiable](q myDB.Querier, id string) (string, error) {
+	return RestoreByTypeContext[T](context.Background(), q, id)
+}
+
+func RestoreByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, id string) (string, error) {
+	var obj T
+	col, ok := softDeleteColumn(reflect.TypeOf(obj))
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+
+	d := dialectOf(q)
+	query := fmt.Sprintf("UPDATE %s SET %s = NULL WHERE %s = %s AND %s IS NOT NULL", d.Quote(obj.TableName()),
+		d.Quote(col), d.Quote("id"), d.Placeholder(1), d.Quote(col))
+	sqlResult, err := q.ExecContext(ctx, query, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to restore: %w", err)
+	}
+
+	rowsAffected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+
+	if rowsAffected == 0 {
+		return "", sql.ErrNoRows
+	}
+	return id, nil
+}
+
+// PurgeByType hard-deletes the rows of T that were deleted at least
+// retention ago, which also removes everything cascading from them.
+func PurgeByType[T types.Identifiable](q myDB.Querier, retention time.Duration) (int64, error) {
+	return PurgeByTypeContext[T](context.Background(), q, retention)
+}
+
+func PurgeByTypeContext[T types.Identifiable](ctx context.Context, q myDB.Querier, retention time.Duration) (int64, error) {
+	var obj T
+	col, ok := softDeleteColumn(reflect.TypeOf(obj))
+	if !ok {
+		return 0, nil
+	}
+
+	d := dialectOf(q)
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s IS NOT NULL AND %s <= %s", d.Quote(obj.TableName()),
+		d.Quote(col), d.Quote(col), d.Placeholder(1))
+	sqlResult, err := q.ExecContext(ctx, query, deletedAt(time.Now().Add(-retention)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge: %w", err)
+	}
+
+	return sqlResult.RowsAffected()
+}
+
+// PurgeTrash purges the recipes that have been in the trash for longer than
+// retention, right away and then once every interval until ctx is done.
+func PurgeTrash(ctx context.Context, store myDB.Store, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := PurgeByTypeContext[types.Recipe](ctx, store, retention)
+		if err != nil {
+			log.Printf("failed to purge trash: %v", err)
+		} else if purged > 0 {
+			log.Printf("purged %d recipes from the trash", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
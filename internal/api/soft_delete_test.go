@@ -0,0 +1,119 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opskrifter-backend/internal/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSoftDelete(t *testing.T) {
+	store := newTestStore(t)
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeSteps = testSteps[:2]
+	id, err := CreateByTypeWithRelations(store, recipe)
+	require.NoError(t, err)
+
+	_, err = DeleteByType[types.Recipe](store, id)
+	require.NoError(t, err)
+
+	_, err = DeleteByType[types.Recipe](store, id)
+	assert.ErrorIs(t, err, sql.ErrNoRows, "a trashed recipe is not deleted again")
+
+	_, err = GetByType[types.Recipe](store, id)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	assert.ErrorIs(t, UpdateCountByType(store, types.Recipe{ID: id}, "views", "+1"), ErrRowsAffectedZero, "counters of trashed recipes stay put")
+
+	_, err = PatchByType[types.Recipe](store, id, MergePatch{"minutes": 1})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	live, err := GetManyByType[types.Recipe](store, QueryOptions{Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	assert.Empty(t, live)
+
+	trashed, err := GetManyByType[types.Recipe](store, QueryOptions{Page: 1, PerPage: 10, Trashed: true, Include: []string{"steps"}})
+	require.NoError(t, err)
+	require.Len(t, trashed, 1)
+	assert.NotNil(t, trashed[0].DeletedAt)
+	assert.Len(t, trashed[0].RecipeSteps, 2, "steps survive in the trash")
+
+	_, err = RestoreByType[types.Recipe](store, id)
+	require.NoError(t, err)
+
+	restored, err := GetByType[types.Recipe](store, id)
+	require.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+
+	_, err = RestoreByType[types.Recipe](store, id)
+	assert.ErrorIs(t, err, sql.ErrNoRows, "only trashed recipes can be restored")
+}
+
+func TestPurgeByType(t *testing.T) {
+	store := newTestStore(t)
+	id, err := CreateByType(store, recipeGenerator.Generate())
+	require.NoError(t, err)
+	_, err = DeleteByType[types.Recipe](store, id)
+	require.NoError(t, err)
+
+	purged, err := PurgeByType[types.Recipe](store, time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, purged, "recipe is younger than the retention")
+
+	purged, err = PurgeByType[types.Recipe](store, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	_, err = RestoreByType[types.Recipe](store, id)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	purged, err = PurgeByType[types.User](store, 0)
+	require.NoError(t, err)
+	assert.Zero(t, purged, "users are not soft deleted")
+}
+
+func TestRouteTrashAndRestore(t *testing.T) {
+	store, router := newTestEnv(t)
+	id, err := CreateByType(store, recipeGenerator.Generate())
+	require.NoError(t, err)
+
+	serve := func(method string, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	require.Equal(t, http.StatusOK, serve("DELETE", "/recipes/"+id).Code)
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/recipes/"+id).Code)
+	assert.Equal(t, http.StatusNotFound, serve("DELETE", "/recipes/"+id).Code)
+	assert.Equal(t, http.StatusNotFound, serve("POST", "/recipes/"+id+"/views").Code)
+
+	like := httptest.NewRequest("POST", "/recipes/"+id+"/like", strings.NewReader(`{"user_id": "`+adminUser.ID+`"}`))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, like)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	_, err = GetRelationByType[types.UserLikedRecipe](store, adminUser.ID, id)
+	assert.ErrorIs(t, err, sql.ErrNoRows, "the like is rolled back with its counter")
+
+	resp = serve("GET", "/recipes/trash")
+	require.Equal(t, http.StatusOK, resp.Code)
+	var trash ListResponse[types.Recipe]
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &trash))
+	require.Len(t, trash.Items, 1)
+	assert.Equal(t, id, trash.Items[0].ID)
+	assert.Equal(t, 1, trash.Total)
+
+	assert.Equal(t, http.StatusOK, serve("POST", "/recipes/"+id+"/restore").Code)
+	assert.Equal(t, http.StatusOK, serve("GET", "/recipes/"+id).Code)
+	assert.Equal(t, http.StatusNotFound, serve("POST", "/recipes/"+id+"/restore").Code)
+
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/users/trash").Code, "users have no trash")
+}
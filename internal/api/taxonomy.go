@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"strings"
+)
+
+var ErrIngredientCycle = errors.New("an ingredient cannot be placed below itself")
+
+// ingredientsBelow is a query listing the ingredients with the ids at the
+// given placeholders and every ingredient below them, so that "Ost" also
+// lists "Gedeost".
+func ingredientsBelow(d Dialect, params []string) string {
+	return fmt.Sprintf("WITH RECURSIVE family(id) AS (SELECT %[1]s FROM %[2]s WHERE %[1]s IN (%[3]s)"+
+		" UNION SELECT below.%[4]s FROM %[5]s AS below JOIN family ON below.%[6]s = family.id) SELECT id FROM family",
+		d.Quote("id"), d.Quote("ingredients"), strings.Join(params, ", "),
+		d.Quote("ingredient_id"), d.Quote("ingredient_parents"), d.Quote("parent_id"))
+}
+
+// ingredientsAbove is a query listing the ingredients with the ids at the
+// given placeholders and every ingredient above them, so that "Gedeost" also
+// lists "Ost".
+func ingredientsAbove(d Dialect, params []string) string {
+	return fmt.Sprintf("WITH RECURSIVE family(id) AS (SELECT %[1]s FROM %[2]s WHERE %[1]s IN (%[3]s)"+
+		" UNION SELECT above.%[4]s FROM %[5]s AS above JOIN family ON above.%[6]s = family.id) SELECT id FROM family",
+		d.Quote("id"), d.Quote("ingredients"), strings.Join(params, ", "),
+		d.Quote("parent_id"), d.Quote("ingredient_parents"), d.Quote("ingredient_id"))
+}
+
+// ingredientsAboveContext returns ids and the ids of every ingredient above
+// them.
+func ingredientsAboveContext(ctx context.Context, q myDB.Querier, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	d := dialectOf(q)
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	var family []string
+	if err := q.SelectContext(ctx, &family, ingredientsAbove(d, placeholders(d, 0, len(ids))), args...); err != nil {
+		return nil, fmt.Errorf("failed to read ingredient taxonomy: %w", err)
+	}
+	return family, nil
+}
+
+func IngredientsNamed(q myDB.Querier, name string) ([]string, error) {
+	return IngredientsNamedContext(context.Background(), q, name)
+}
+
+// IngredientsNamedContext returns the ids of the ingredients called name, by
+// their own name or an alias, compared the way normalizeName folds them
+// through the indexed name_key columns.
+func IngredientsNamedContext(ctx context.Context, q myDB.Querier, name string) ([]string, error) {
+	key := normalizeName(name)
+	if key == "" {
+		return nil, nil
+	}
+
+	d := dialectOf(q)
+	query := fmt.Sprintf("SELECT %[1]s FROM %[2]s WHERE %[3]s = %[4]s"+
+		" UNION SELECT %[5]s FROM %[6]s WHERE %[3]s = %[7]s",
+		d.Quote("id"), d.Quote("ingredients"), d.Quote("name_key"), d.Placeholder(1),
+		d.Quote("ingredient_id"), d.Quote("ingredient_aliases"), d.Placeholder(2))
+
+	var ids []string
+	if err := q.SelectContext(ctx, &ids, query, key, key); err != nil {
+		return nil, fmt.Errorf("failed to look up ingredient %q: %w", name, err)
+	}
+	return ids, nil
+}
+
+func SetIngredientParent(q myDB.Querier, id string, parentID string) error {
+	return SetIngredientParentContext(context.Background(), q, id, parentID)
+}
+
+// SetIngredientParentContext places the ingredient id below parentID,
+// replacing its former parent. The taxonomy is kept free of cycles.
+func SetIngredientParentContext(ctx context.Context, q myDB.Querier, id string, parentID string) error {
+	return myDB.WithTxContext(ctx, q, func(tx myDB.Querier) error {
+		d := dialectOf(tx)
+		if _, err := GetByTypeContext[types.Ingredient](ctx, tx, id); err != nil {
+			return err
+		}
+
+		var above []string
+		if err := tx.SelectContext(ctx, &above, ingredientsAbove(d, []string{d.Placeholder(1)}), parentID); err != nil {
+			return fmt.Errorf("failed to read ingredient taxonomy: %w", err)
+		}
+		for _, ancestor := range above {
+			if ancestor == id {
+				return fmt.Errorf("%w: %s is already below %s", ErrIngredientCycle, parentID, id)
+			}
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES (%s, %s)", d.Quote("ingredient_parents"),
+			d.Quote("ingredient_id"), d.Quote("parent_id"), d.Placeholder(1), d.Placeholder(2)) +
+			onConflict(d, []string{"ingredient_id"}, []string{"parent_id"})
+		if _, err := tx.ExecContext(ctx, query, id, parentID); err != nil {
+			return fmt.Errorf("failed to set the parent of %s: %w", id, err)
+		}
+		return nil
+	})
+}
+
+func RemoveIngredientParent(q myDB.Querier, id string) error {
+	return RemoveIngredientParentContext(context.Background(), q, id)
+}
+
+// RemoveIngredientParentContext moves the ingredient id to the top of the
+// taxonomy.
+func RemoveIngredientParentContext(ctx context.Context, q myDB.Querier, id string) error {
+	d := dialectOf(q)
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", d.Quote("ingredient_parents"), d.Quote("ingredient_id"), d.Placeholder(1))
+	res, err := q.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove the parent of %s: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrRowsAffectedZero
+	}
+	return nil
+}
+
+// IngredientFamily is an ingredient with its place in the taxonomy and its
+// other names.
+type IngredientFamily struct {
+	types.Ingredient
+	Parent   *types.Ingredient       `json:"parent"`
+	Children []types.Ingredient      `json:"children"`
+	Aliases  []types.IngredientAlias `json:"aliases"`
+}
+
+func GetIngredientFamily(q myDB.Querier, id string) (IngredientFamily, error) {
+	return GetIngredientFamilyContext(context.Background(), q, id)
+}
+
+func GetIngredientFamilyContext(ctx context.Context, q myDB.Querier, id string) (IngredientFamily, error) {
+	d := dialectOf(q)
+	family := IngredientFamily{Children: []types.Ingredient{}, Aliases: []types.IngredientAlias{}}
+
+	ingredient, err := GetByTypeContext[types.Ingredient](ctx, q, id)
+	if err != nil {
+		return family, err
+	}
+	family.Ingredient = ingredient
+
+	var parents []types.Ingredient
+	query := fmt.Sprintf("SELECT %[1]s.* FROM %[1]s JOIN %[2]s ON %[2]s.%[3]s = %[1]s.%[4]s WHERE %[2]s.%[5]s = %[6]s",
+		d.Quote("ingredients"), d.Quote("ingredient_parents"), d.Quote("parent_id"), d.Quote("id"), d.Quote("ingredient_id"), d.Placeholder(1))
+	if err := q.SelectContext(ctx, &parents, query, id); err != nil {
+		return family, fmt.Errorf("failed to read the parent of %s: %w", id, err)
+	}
+	if len(parents) > 0 {
+		family.Parent = &parents[0]
+	}
+
+	query = fmt.Sprintf("SELECT %[1]s.* FROM %[1]s JOIN %[2]s ON %[2]s.%[3]s = %[1]s.%[4]s WHERE %[2]s.%[5]s = %[6]s ORDER BY %[1]s.%[7]s",
+		d.Quote("ingredients"), d.Quote("ingredient_parents"), d.Quote("ingredient_id"), d.Quote("id"), d.Quote("parent_id"), d.Placeholder(1), d.Quote("name"))
+	if err := q.SelectContext(ctx, &family.Children, query, id); err != nil {
+		return family, fmt.Errorf("failed to read the children of %s: %w", id, err)
+	}
+
+	query = fmt.Sprintf("SELECT * FROM %s WHERE %s = %s ORDER BY %s",
+		d.Quote("ingredient_aliases"), d.Quote("ingredient_id"), d.Placeholder(1), d.Quote("name"))
+	if err := q.SelectContext(ctx, &family.Aliases, query, id); err != nil {
+		return family, fmt.Errorf("failed to read the aliases of %s: %w", id, err)
+	}
+	return family, nil
+}
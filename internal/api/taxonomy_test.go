@@ -0,0 +1,223 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opskrifter-backend/internal/middleware"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Seeded by migration 005: Ost is above every cheese, Fløde above every
+// cream, and Piskefløde is an alias of Fløde 38 %.
+const (
+	seededAgurk      = "5"
+	seededFlode38    = "124"
+	seededGedeost    = "150"
+	seededMozzarella = "388"
+	seededOst        = "407"
+	seededFlode      = "654"
+)
+
+// newRecipeUsing creates a recipe called name using the ingredients ids.
+func newRecipeUsing(t *testing.T, store myDB.Store, name string, ids ...string) string {
+	recipe := recipeGenerator.Generate()
+	recipe.Name = name
+	for _, id := range ids {
+		recipe.RecipeIngredients = append(recipe.RecipeIngredients, types.RecipeIngredient{IngredientId: id, Amount: "1 stk"})
+	}
+	id, err := CreateByTypeWithRelations(store, recipe)
+	require.NoError(t, err)
+	return id
+}
+
+func TestIngredientsNamed(t *testing.T) {
+	store := newTestStore(t)
+
+	ids, err := IngredientsNamed(store, " OST ")
+	require.NoError(t, err)
+	assert.Equal(t, []string{seededOst}, ids)
+
+	ids, err = IngredientsNamed(store, "piskefloede")
+	require.NoError(t, err)
+	assert.Equal(t, []string{seededFlode38}, ids, "aliases name ingredients too")
+
+	ids, err = IngredientsNamed(store, "æble")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"644"}, ids, "Æ folds like its lower case")
+
+	ids, err = IngredientsNamed(store, "ØRRED")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"652"}, ids)
+
+	ids, err = IngredientsNamed(store, "os")
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestIngredientNameKeys(t *testing.T) {
+	store := newTestStore(t)
+
+	ingredients, err := GetAllByTypeWithOptions[types.Ingredient](store, QueryOptions{})
+	require.NoError(t, err)
+	for _, ingredient := range ingredients {
+		assert.Equal(t, normalizeName(ingredient.Name), ingredient.NameKey, "migration 006 folds %q like normalizeName", ingredient.Name)
+	}
+	aliases, err := GetAllByTypeWithOptions[types.IngredientAlias](store, QueryOptions{})
+	require.NoError(t, err)
+	for _, alias := range aliases {
+		assert.Equal(t, normalizeName(alias.Name), alias.NameKey, "migration 006 folds %q like normalizeName", alias.Name)
+	}
+
+	ids, err := IngredientsNamed(store, "pop corn")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"440"}, ids, "names differing in case were merged")
+
+	id, err := CreateByType(store, types.Ingredient{Name: "Ørredrogn"})
+	require.NoError(t, err)
+	_, err = CreateByType(store, types.Ingredient{Name: "ørredrogn"})
+	assert.Error(t, err, "folded names are unique")
+
+	_, err = PatchByType[types.Ingredient](store, id, MergePatch{"name": "Laksrogn"})
+	require.NoError(t, err)
+	ids, err = IngredientsNamed(store, "laksrogn")
+	require.NoError(t, err)
+	assert.Equal(t, []string{id}, ids, "patching the name refolds it")
+}
+
+func TestIngredientTaxonomy(t *testing.T) {
+	store := newTestStore(t)
+
+	cheese := newRecipeUsing(t, store, "Gedeostsalat", seededGedeost, seededAgurk)
+	salad := newRecipeUsing(t, store, "Agurkesalat", seededAgurk)
+	cream := newRecipeUsing(t, store, "Flødekartofler", seededFlode)
+
+	list := func(opts QueryOptions) []string {
+		opts.Page, opts.PerPage = 1, 10
+		recipes, err := GetManyByType[types.Recipe](store, opts)
+		require.NoError(t, err)
+		var ids []string
+		for _, recipe := range recipes {
+			ids = append(ids, recipe.ID)
+		}
+		return ids
+	}
+
+	assert.ElementsMatch(t, []string{salad, cream}, list(QueryOptions{ExcludeIngredients: []string{seededOst}}), "excluding Ost excludes every cheese")
+	assert.Equal(t, []string{cheese}, list(QueryOptions{RequireIngredients: []string{seededOst}}), "any cheese is Ost")
+
+	matches, err := MatchPantry(store, Pantry{Ingredients: []string{seededFlode38}, MaxMissing: -1}, QueryOptions{Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	require.Len(t, matches, 1, "Fløde 38 % covers Fløde")
+	assert.Equal(t, cream, matches[0].ID)
+	assert.Empty(t, matches[0].Missing)
+
+	assert.ErrorIs(t, SetIngredientParent(store, seededOst, seededGedeost), ErrIngredientCycle)
+	assert.ErrorIs(t, SetIngredientParent(store, seededAgurk, seededAgurk), ErrIngredientCycle)
+
+	require.NoError(t, SetIngredientParent(store, seededAgurk, seededOst))
+	assert.Empty(t, list(QueryOptions{ExcludeIngredients: []string{seededOst}, RequireIngredients: []string{seededAgurk}}))
+
+	family, err := GetIngredientFamily(store, seededOst)
+	require.NoError(t, err)
+	assert.Contains(t, family.Children, types.Ingredient{ID: seededAgurk, Name: "Agurk", NameKey: "agurk"})
+	assert.Nil(t, family.Parent)
+
+	require.NoError(t, RemoveIngredientParent(store, seededAgurk))
+	assert.ErrorIs(t, RemoveIngredientParent(store, seededAgurk), ErrRowsAffectedZero)
+
+	family, err = GetIngredientFamily(store, seededFlode38)
+	require.NoError(t, err)
+	require.NotNil(t, family.Parent)
+	assert.Equal(t, "Fløde", family.Parent.Name)
+	require.Len(t, family.Aliases, 1)
+	assert.Equal(t, "Piskefløde", family.Aliases[0].Name)
+
+	suggestions, err := SuggestIngredients(store, "piskefl", 5)
+	require.NoError(t, err)
+	require.NotEmpty(t, suggestions)
+	assert.Equal(t, "Fløde 38 %", suggestions[0].Name, "aliases are suggested")
+}
+
+func TestSearchIngredientTaxonomy(t *testing.T) {
+	store := newSearchStore(t)
+
+	pizza := newRecipeUsing(t, store, "Pizza", seededMozzarella)
+	toast := newRecipeUsing(t, store, "Ostetoast")
+	newRecipeUsing(t, store, "Agurkesalat", seededAgurk)
+
+	hits, err := SearchRecipes(store, "ost", QueryOptions{Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	require.Len(t, hits, 2)
+	assert.Equal(t, toast, hits[0].ID, "text matches rank first")
+	assert.Equal(t, pizza, hits[1].ID, "recipes using any cheese match")
+	assert.Equal(t, SnippetStart+"Mozzarella"+SnippetEnd, hits[1].Snippet)
+
+	total, err := CountSearchRecipes(store, "ost", QueryOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+}
+
+func TestRouteIngredientTaxonomy(t *testing.T) {
+	t.Setenv("ADMIN_KEY", "secret")
+	_, router := newTestEnv(t)
+
+	do := func(method string, target string, body any, key string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(middleware.AdminKeyHeader, key)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	parent := map[string]string{"parent_id": seededOst}
+	assert.Equal(t, http.StatusUnauthorized, do("PUT", "/ingredients/"+seededAgurk+"/parent", parent, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("PUT", "/ingredients/"+seededAgurk+"/parent", parent, "wrong").Code)
+
+	resp := do("PUT", "/ingredients/"+seededAgurk+"/parent", parent, "secret")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var family IngredientFamily
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &family))
+	require.NotNil(t, family.Parent)
+	assert.Equal(t, "Ost", family.Parent.Name)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, do("PUT", "/ingredients/"+seededOst+"/parent", map[string]string{"parent_id": seededAgurk}, "secret").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, do("PUT", "/ingredients/"+seededAgurk+"/parent", map[string]string{"parent_id": "nothing"}, "secret").Code)
+	assert.Equal(t, http.StatusNotFound, do("PUT", "/ingredients/nothing/parent", parent, "secret").Code)
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/ingredients/"+seededAgurk+"/parent", map[string]string{}, "secret").Code)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/ingredients/"+seededAgurk+"/parent", nil, "secret").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/ingredients/"+seededAgurk+"/parent", nil, "secret").Code)
+
+	alias := types.IngredientAlias{Name: "Slangeagurk", IngredientID: seededAgurk}
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/ingredient-aliases/", alias, "").Code)
+	resp = do("POST", "/ingredient-aliases/", alias, "secret")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.Equal(t, http.StatusConflict, do("POST", "/ingredient-aliases/", alias, "secret").Code)
+
+	resp = do("GET", "/ingredients/"+seededAgurk+"/family", nil, "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &family))
+	require.Len(t, family.Aliases, 1)
+	assert.Equal(t, "Slangeagurk", family.Aliases[0].Name)
+	assert.Nil(t, family.Parent)
+
+	resp = do("GET", "/ingredient-aliases/?name=Slangeagurk", nil, "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	var aliases ListResponse[types.IngredientAlias]
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &aliases))
+	require.Len(t, aliases.Items, 1)
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/ingredient-aliases/"+aliases.Items[0].ID, nil, "secret").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/ingredients/nothing/family", nil, "").Code)
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"opskrifter-backend/pkg/myDB"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrValidation = errors.New("validation failed")
+
+var ErrInvalidTag = errors.New("invalid struct tag")
+
+// FieldError is one rule of a validate tag that a field does not meet.
+// Field is the JSON path of the field, e.g. RecipeIngredients[1].amount.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every failing field. It matches ErrValidation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Field + " " + f.Message
+	}
+	return fmt.Sprintf("%s: %s", ErrValidation, strings.Join(messages, ", "))
+}
+
+func (e *ValidationError) Unwrap() error { return ErrValidation }
+
+// Validate checks obj against the validate:"..." tags of its fields and of
+// the structs in its relation slices. The rules are required, min=n and
+// max=n, where min and max bound the length of strings and slices and the
+// value of numbers. Rows of a relation may not repeat the key of another.
+// A tag with any other rule is an ErrInvalidTag.
+func Validate(obj any) error {
+	var fields []FieldError
+	if err := validateStruct(reflect.ValueOf(obj), "", &fields); err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+func validateStruct(v reflect.Value, prefix string, errs *[]FieldError) error {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	t := v.Type()
+	keys := map[int]int{}
+	for _, rel := range relationsOf(t) {
+		keys[rel.index] = rel.keyIdx
+	}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		path := prefix + jsonName(field)
+		value := v.Field(i)
+
+		if rules, ok := field.Tag.Lookup("validate"); ok {
+			for _, rule := range strings.Split(rules, ",") {
+				name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+				msg, ok, err := checkRule(value, name, param)
+				if err != nil {
+					return fmt.Errorf("%w: %s.%s: validate: %v", ErrInvalidTag, t.Name(), field.Name, err)
+				}
+				if !ok {
+					*errs = append(*errs, FieldError{Field: path, Rule: name, Param: param, Message: msg})
+				}
+			}
+		}
+
+		if value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct {
+			for j := range value.Len() {
+				if err := validateStruct(value.Index(j), fmt.Sprintf("%s[%d].", path, j), errs); err != nil {
+					return err
+				}
+			}
+		}
+
+		if key, ok := keys[i]; ok && key >= 0 {
+			checkUniqueKeys(value, key, path, errs)
+		}
+	}
+	return nil
+}
+
+// checkUniqueKeys adds an error for every row of the relation rows whose key
+// field repeats the key of an earlier row, since a relation stores each key
+// once. Rows without a key yet are left alone.
+func checkUniqueKeys(rows reflect.Value, key int, path string, errs *[]FieldError) {
+	first := map[string]int{}
+	for j := range rows.Len() {
+		k := rows.Index(j).Field(key).String()
+		if k == "" {
+			continue
+		}
+		if i, seen := first[k]; seen {
+			*errs = append(*errs, FieldError{
+				Field:   fmt.Sprintf("%s[%d].%s", path, j, jsonName(rows.Type().Elem().Field(key))),
+				Rule:    "unique",
+				Message: fmt.Sprintf("repeats %s[%d]", path, i),
+			})
+			continue
+		}
+		first[k] = j
+	}
+}
+
+// checkValidateTag reports a rule of a validate tag checkRule does not know.
+func checkValidateTag(tag string) error {
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "required":
+		case "min", "max":
+			if _, err := strconv.ParseFloat(param, 64); err != nil {
+				return fmt.Errorf("%s needs a number, got %q", name, param)
+			}
+		default:
+			return fmt.Errorf("unknown rule %q", name)
+		}
+	}
+	return nil
+}
+
+// CheckTags checks the validate, server and fold tags of models and of the rows of
+// their relations, so that a mistyped tag stops startup instead of failing
+// the first request reaching it.
+func CheckTags(models ...myDB.Model) error {
+	var errs []error
+	for _, model := range models {
+		checkTypeTags(reflect.TypeOf(model), &errs)
+	}
+	return errors.Join(errs...)
+}
+
+func checkTypeTags(t reflect.Type, errs *[]error) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if tag, ok := field.Tag.Lookup("validate"); ok {
+			if err := checkValidateTag(tag); err != nil {
+				*errs = append(*errs, fmt.Errorf("%w: %s.%s: validate: %v", ErrInvalidTag, t.Name(), field.Name, err))
+			}
+		}
+		if tag, ok := field.Tag.Lookup("server"); ok {
+			if err := checkServerTag(tag); err != nil {
+				*errs = append(*errs, fmt.Errorf("%w: %s.%s: server: %v", ErrInvalidTag, t.Name(), field.Name, err))
+			}
+		}
+		if column, ok := field.Tag.Lookup("fold"); ok {
+			if err := checkFoldTag(t, column); err != nil {
+				*errs = append(*errs, fmt.Errorf("%w: %s.%s: fold: %v", ErrInvalidTag, t.Name(), field.Name, err))
+			}
+		}
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			checkTypeTags(field.Type.Elem(), errs)
+		}
+	}
+}
+
+// checkRule reports whether value meets the rule, and the message when it
+// does not. A rule it does not know is an error.
+func checkRule(value reflect.Value, rule string, param string) (string, bool, error) {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return "is required", rule != "required", nil
+		}
+		value = value.Elem()
+	}
+
+	switch rule {
+	case "required":
+		return "is required", !value.IsZero(), nil
+	case "min", "max":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return "", false, fmt.Errorf("%s needs a number, got %q", rule, param)
+		}
+
+		size, unit := measure(value)
+		if rule == "min" && size < limit {
+			return fmt.Sprintf("must be at least %s%s", param, unit), false, nil
+		}
+		if rule == "max" && size > limit {
+			return fmt.Sprintf("must be at most %s%s", param, unit), false, nil
+		}
+		return "", true, nil
+	}
+
+	return "", false, fmt.Errorf("unknown rule %q", rule)
+}
+
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	}
+	return 0, ""
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opskrifter-backend/internal/middleware"
+	"opskrifter-backend/internal/problem"
+	"opskrifter-backend/internal/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	recipe := recipeGenerator.Generate()
+	require.NoError(t, Validate(recipe))
+
+	recipe.Name = ""
+	recipe.Minutes = -5
+	recipe.Description = strings.Repeat("ø", 10001)
+	recipe.RecipeSteps = []types.RecipeStep{{Step: "ok"}, {Step: ""}}
+
+	err := Validate(recipe)
+	require.ErrorIs(t, err, ErrValidation)
+
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []FieldError{
+		{Field: "name", Rule: "required", Message: "is required"},
+		{Field: "name", Rule: "min", Param: "1", Message: "must be at least 1 characters"},
+		{Field: "minutes", Rule: "min", Param: "0", Message: "must be at least 0"},
+		{Field: "description", Rule: "max", Param: "10000", Message: "must be at most 10000 characters"},
+		{Field: "RecipeSteps[1].step", Rule: "required", Message: "is required"},
+	}, validationErr.Fields)
+
+	require.NoError(t, Validate(strings.Repeat("x", 500)), "only structs have rules")
+}
+
+func TestValidateBeforeWrites(t *testing.T) {
+	store := newTestStore(t)
+	before, err := GetCountByType(store, types.Ingredient{})
+	require.NoError(t, err)
+
+	_, err = CreateByType(store, types.Ingredient{})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	id, err := CreateByType(store, recipeGenerator.Generate())
+	require.NoError(t, err)
+
+	_, err = PatchByType[types.Recipe](store, id, MergePatch{"minutes": -1})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeIngredients = []types.RecipeIngredient{{Name: "Ny ingrediens", Amount: strings.Repeat("1", 101)}}
+	_, err = CreateByTypeWithRelations(store, recipe)
+	assert.ErrorIs(t, err, ErrValidation)
+
+	after, err := GetCountByType(store, types.Ingredient{})
+	require.NoError(t, err)
+	assert.Equal(t, before, after, "nothing is written for an invalid recipe")
+}
+
+func TestRouteValidationErrors(t *testing.T) {
+	t.Setenv("ADMIN_KEY", "secret")
+	_, router := newTestEnv(t)
+	recipe := recipeGenerator.Generate()
+	recipe.Name = ""
+	body, _ := json.Marshal(recipe)
+
+	req := httptest.NewRequest("POST", "/recipes/", bytes.NewBuffer(body))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+
+	assert.Equal(t, problem.ContentType, resp.Header().Get("Content-Type"))
+	var got struct {
+		problem.Problem
+		Errors []FieldError `json:"errors"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	assert.Equal(t, problem.TypeBase+"validation-failed", got.Type)
+	require.NotEmpty(t, got.Errors)
+	assert.Equal(t, "name", got.Errors[0].Field)
+	assert.Equal(t, "required", got.Errors[0].Rule)
+
+	batch, _ := json.Marshal(BatchRequest[types.Ingredient]{
+		Mode:       BatchBestEffort,
+		Operations: []BatchOperation[types.Ingredient]{{Op: BatchAdd}},
+	})
+	req = httptest.NewRequest("POST", "/ingredients/batch", bytes.NewBuffer(batch))
+	req.Header.Set(middleware.AdminKeyHeader, "secret")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusMultiStatus, resp.Code)
+
+	var results BatchResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &results))
+	assert.Equal(t, http.StatusUnprocessableEntity, results.Results[0].Status)
+	assert.Equal(t, "name", results.Results[0].Errors[0].Field)
+}
+
+func TestValidateDuplicateRelationKeys(t *testing.T) {
+	store := newTestStore(t)
+
+	recipe := recipeGenerator.Generate()
+	recipe.RecipeIngredients = []types.RecipeIngredient{
+		{IngredientId: "5", Amount: "1 stk"},
+		{IngredientId: "6", Amount: "2 stk"},
+		{IngredientId: "5", Amount: "3 stk"},
+	}
+
+	var validationErr *ValidationError
+	require.ErrorAs(t, Validate(recipe), &validationErr)
+	assert.Equal(t, []FieldError{
+		{Field: "RecipeIngredients[2].ingredient_id", Rule: "unique", Message: "repeats RecipeIngredients[0]"},
+	}, validationErr.Fields)
+
+	_, err := CreateByTypeWithRelations(store, recipe)
+	assert.ErrorIs(t, err, ErrValidation)
+
+	recipe.RecipeIngredients = recipe.RecipeIngredients[:2]
+	id, err := CreateByTypeWithRelations(store, recipe)
+	require.NoError(t, err)
+
+	recipe.ID = id
+	recipe.RecipeIngredients = []types.RecipeIngredient{{IngredientId: "5", Amount: "1 stk"}, {Name: "Agurk", Amount: "2 stk"}}
+	_, err = UpdateByTypeWithRelations(store, recipe)
+	require.ErrorAs(t, err, &validationErr, "names are checked once they are resolved")
+	assert.Equal(t, "RecipeIngredients[1].ingredient_id", validationErr.Fields[0].Field)
+}
+
+type brokenTagRow struct {
+	Name string `validate:"max=ten"`
+}
+
+type brokenTags struct {
+	ID      string `db:"id"`
+	Created string `server:"later"`
+	Title   string `validate:"required,long"`
+	Folded  string `db:"folded" fold:"nowhere"`
+	Rows    []brokenTagRow
+}
+
+func (brokenTags) TableName() string { return "broken_tags" }
+
+func TestCheckTags(t *testing.T) {
+	assert.NoError(t, CheckTags(Models...))
+
+	err := CheckTags(brokenTags{})
+	assert.ErrorIs(t, err, ErrInvalidTag)
+	assert.ErrorContains(t, err, "brokenTags.Created")
+	assert.ErrorContains(t, err, "brokenTags.Title")
+	assert.ErrorContains(t, err, "brokenTags.Folded")
+	assert.ErrorContains(t, err, "brokenTagRow.Name")
+}
+
+func TestInvalidTagsWithoutCheckTags(t *testing.T) {
+	assert.NotPanics(t, func() {
+		err := Validate(brokenTags{Title: "Kage"})
+		assert.ErrorIs(t, err, ErrInvalidTag)
+		assert.ErrorContains(t, err, "brokenTags.Title")
+
+		err = Validate(brokenTagRow{Name: "Kage"})
+		assert.ErrorIs(t, err, ErrInvalidTag)
+		assert.ErrorContains(t, err, "brokenTagRow.Name")
+	})
+
+	type stamped struct {
+		ID      string `db:"id"`
+		Created string `db:"created_at" server:"later"`
+	}
+	assert.NotPanics(t, func() {
+		_, _, _, err := BuildInsertQuery(SQLite, stamped{})
+		assert.ErrorIs(t, err, ErrInvalidTag)
+		assert.ErrorContains(t, err, "Created")
+	})
+}
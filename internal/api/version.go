@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrVersionMismatch is returned when a write expected another version of
+// the row than the one stored.
+var ErrVersionMismatch = errors.New("version does not match")
+
+// versionColumn returns the field of t tagged version:"true". Writes to such
+// types bump the version and can be made conditional on it.
+func versionColumn(t reflect.Type) (reflect.StructField, bool) {
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if _, ok := field.Tag.Lookup("version"); ok {
+			if _, isColumn := columnTag(field); isColumn {
+				return field, true
+			}
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func versionOf(obj any) (int, bool) {
+	v := reflect.ValueOf(obj)
+	field, ok := versionColumn(v.Type())
+	if !ok {
+		return 0, false
+	}
+	return int(v.FieldByIndex(field.Index).Int()), true
+}
+
+// setVersion also works when T is an interface, by swapping in a copy of the
+// struct it holds.
+func setVersion[T any](obj *T, version int) {
+	v := reflect.ValueOf(obj).Elem()
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		holder := v
+		v = reflect.New(holder.Elem().Type()).Elem()
+		v.Set(holder.Elem())
+		defer holder.Set(v)
+	}
+
+	if field, ok := versionColumn(v.Type()); ok {
+		v.FieldByIndex(field.Index).SetInt(int64(version))
+	}
+}
+
+type expectedVersionKey struct{}
+
+// WithExpectedVersion makes updates, patches and deletes run with ctx fail
+// with ErrVersionMismatch unless the stored row still has version.
+func WithExpectedVersion(ctx context.Context, version int) context.Context {
+	return withExpectedVersions(ctx, []int{version})
+}
+
+// withExpectedVersions is WithExpectedVersion for a row that may have any of
+// versions, as an If-Match header listing several tags asks for.
+func withExpectedVersions(ctx context.Context, versions []int) context.Context {
+	return context.WithValue(ctx, expectedVersionKey{}, versions)
+}
+
+func expectedVersions(ctx context.Context) ([]int, bool) {
+	versions, ok := ctx.Value(expectedVersionKey{}).([]int)
+	return versions, ok
+}
+
+// expectedVersion picks the version an update of the row of obj with id is
+// made conditional on: the stored one when it is among versions, and
+// otherwise one that fails the update.
+func expectedVersion(ctx context.Context, q myDB.Querier, obj types.Identifiable, id string, versions []int) (int, error) {
+	field, ok := versionColumn(reflect.TypeOf(obj))
+	if !ok || len(versions) == 1 {
+		return versions[0], nil
+	}
+
+	d := dialectOf(q)
+	col, _ := columnTag(field)
+	var stored int
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s", d.Quote(col), d.Quote(obj.TableName()), d.Quote("id"), d.Placeholder(1)) + andLive(d, reflect.TypeOf(obj))
+	err := q.GetContext(ctx, &stored, query, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if err == nil && slices.Contains(versions, stored) {
+		return stored, nil
+	}
+	return versions[0], nil
+}
+
+// missingOrStale explains why a conditional write on the row of obj with id
+// touched no rows.
+func missingOrStale(ctx context.Context, q myDB.Querier, obj types.Identifiable, id string) error {
+	d := dialectOf(q)
+	var exists int
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s = %s", d.Quote(obj.TableName()), d.Quote("id"), d.Placeholder(1)) + andLive(d, reflect.TypeOf(obj))
+	if err := q.GetContext(ctx, &exists, query, id); err != nil {
+		return err
+	}
+	return ErrVersionMismatch
+}
+
+// ETag is the version followed by a hash of the representation, so the tag
+// changes with counters such as likes while If-Match only looks at the
+// version.
+func ETag(version int, body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"%d-%s"`, version, hex.EncodeToString(sum[:8]))
+}
+
+func parseETagVersion(tag string) (int, error) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	tag = strings.Trim(tag, `"`)
+	version, _, _ := strings.Cut(tag, "-")
+	return strconv.Atoi(version)
+}
+
+// ifMatchContext carries the versions named by the If-Match header of r into
+// the returned context, following RFC 9110 13.1.1: "*" matches any stored
+// row, and otherwise one of the listed tags has to match. If-Match compares
+// strongly, so weak tags and tags that are not ours can never match.
+func ifMatchContext(r *http.Request) (context.Context, error) {
+	header := strings.TrimSpace(strings.Join(r.Header.Values("If-Match"), ","))
+	if header == "" {
+		return r.Context(), nil
+	}
+
+	var versions []int
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return r.Context(), nil
+		}
+		if tag == "" || strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		if version, err := parseETagVersion(tag); err == nil && !slices.Contains(versions, version) {
+			versions = append(versions, version)
+		}
+	}
+
+	if len(versions) == 0 {
+		return nil, ErrVersionMismatch
+	}
+	return withExpectedVersions(r.Context(), versions), nil
+}
+
+// noneMatch reports whether etag is listed in an If-None-Match header, using
+// the weak comparison RFC 9110 asks for.
+func noneMatch(header string, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opskrifter-backend/internal/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateByTypeVersion(t *testing.T) {
+	store := newTestStore(t)
+	id, err := CreateByType(store, recipeGenerator.Generate())
+	require.NoError(t, err)
+
+	recipe, err := GetByType[types.Recipe](store, id)
+	require.NoError(t, err)
+	assert.Equal(t, 1, recipe.Version)
+
+	recipe.Name = "First writer"
+	_, err = UpdateByType(store, recipe)
+	require.NoError(t, err)
+
+	recipe.Name = "Second writer"
+	_, err = UpdateByType(store, recipe)
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+
+	stored, err := GetByType[types.Recipe](store, id)
+	require.NoError(t, err)
+	assert.Equal(t, "First writer", stored.Name)
+	assert.Equal(t, 2, stored.Version)
+
+	_, err = PatchByTypeContext[types.Recipe](WithExpectedVersion(t.Context(), 1), store, id, MergePatch{"minutes": 5})
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+
+	_, err = PatchByType[types.Recipe](store, id, MergePatch{"version": 10})
+	assert.ErrorIs(t, err, ErrInvalidPatch)
+
+	_, err = DeleteByTypeContext[types.Recipe](WithExpectedVersion(t.Context(), 1), store, id)
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+
+	_, err = DeleteByTypeContext[types.Recipe](WithExpectedVersion(t.Context(), 2), store, id)
+	require.NoError(t, err)
+
+	recipe.ID = "does-not-exist"
+	_, err = UpdateByType(store, recipe)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestVersionHandlers(t *testing.T) {
+	store, router := newTestEnv(t)
+	id, err := CreateByType(store, recipeGenerator.Generate())
+	require.NoError(t, err)
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/recipes/"+id, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("")
+	require.Equal(t, http.StatusOK, resp.Code)
+	etag := resp.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	resp = get(etag)
+	assert.Equal(t, http.StatusNotModified, resp.Code)
+	assert.Empty(t, resp.Body.String())
+
+	var recipe types.Recipe
+	require.NoError(t, json.Unmarshal(get("").Body.Bytes(), &recipe))
+	recipe.Name = "Updated"
+	body, _ := json.Marshal(recipe)
+	req := httptest.NewRequest("PUT", "/recipes/", bytes.NewBuffer(body))
+	req.Header.Set("If-Match", etag)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	resp = get(etag)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.NotEqual(t, etag, resp.Header().Get("ETag"))
+
+	req = httptest.NewRequest("PUT", "/recipes/", bytes.NewBuffer(body))
+	req.Header.Set("If-Match", etag)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+
+	recipe.Version = 0
+	body, _ = json.Marshal(recipe)
+	req = httptest.NewRequest("PUT", "/recipes/", bytes.NewBuffer(body))
+	req.Header.Set("If-Match", etag)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code, "If-Match alone guards the update")
+
+	req = httptest.NewRequest("PATCH", "/recipes/"+id, bytes.NewBufferString(`{"minutes": 1}`))
+	req.Header.Set("Content-Type", MergePatchContentType)
+	req.Header.Set("If-Match", etag)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+
+	req = httptest.NewRequest("DELETE", "/recipes/"+id, nil)
+	req.Header.Set("If-Match", `"not-a-version"`)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+
+	recipe.Version = 0
+	body, _ = json.Marshal(recipe)
+	put := func(ifMatch string) int {
+		req := httptest.NewRequest("PUT", "/recipes/", bytes.NewBuffer(body))
+		req.Header.Set("If-Match", ifMatch)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	current := func() string { return get("").Header().Get("ETag") }
+
+	assert.Equal(t, http.StatusOK, put(etag+", "+current()), "any listed tag may match")
+	assert.Equal(t, http.StatusOK, put(current()+", "+etag))
+	assert.Equal(t, http.StatusPreconditionFailed, put("W/"+current()), "If-Match compares strongly")
+	assert.Equal(t, http.StatusPreconditionFailed, put(etag+", W/"+current()))
+	assert.Equal(t, http.StatusOK, put("*"))
+
+	req = httptest.NewRequest("DELETE", "/recipes/"+id, nil)
+	req.Header.Set("If-Match", get("").Header().Get("ETag"))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
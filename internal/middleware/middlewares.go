@@ -2,13 +2,19 @@ package middleware
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"io"
+	"mime"
 	"net/http"
+	"opskrifter-backend/internal/problem"
 	"os"
 	"slices"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	_ "github.com/joho/godotenv/autoload"
 )
 
@@ -18,7 +24,7 @@ func APIKeyAuth(next http.Handler) http.Handler {
 		expectedKey := os.Getenv("API_KEY")
 
 		if apiKey == "" || apiKey != expectedKey && false {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			problem.Write(w, r, problem.New("unauthorized", http.StatusUnauthorized, "missing or wrong API key"))
 			return
 		}
 
@@ -26,16 +32,74 @@ func APIKeyAuth(next http.Handler) http.Handler {
 	})
 }
 
+// AdminKeyHeader carries the key of the admin endpoints, which is set in the
+// ADMIN_KEY environment variable.
+const AdminKeyHeader = "X-Admin-Key"
+
+// AdminWrites lets reads through and answers every other request with 401
+// unless it carries the admin key. Without an ADMIN_KEY nobody can write.
+func AdminWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !IsAdmin(r) {
+			problem.Write(w, r, problem.New("unauthorized", http.StatusUnauthorized, "missing or wrong admin key"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IsAdmin reports whether r carries the admin key.
+func IsAdmin(r *http.Request) bool {
+	expected := os.Getenv("ADMIN_KEY")
+	key := r.Header.Get(AdminKeyHeader)
+	return expected != "" && subtle.ConstantTimeCompare([]byte(key), []byte(expected)) == 1
+}
+
+// RequestID gives every request an id, taken from the X-Request-Id header
+// when the client sent one, and echoes it in the response. Error responses
+// carry it so that a report can be matched with the logs.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(problem.RequestIDHeader)
+		if id == "" || len(id) > 200 {
+			id = uuid.NewString()
+		}
+
+		w.Header().Set(problem.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(problem.WithRequestID(r.Context(), id)))
+	})
+}
+
+// Timeout cancels the request context after d, which stops the database
+// work of a request that takes too long.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 func ValidateJSONMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Content-Type") != "application/json" {
-			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		if !isJSONContentType(r.Header.Get("Content-Type")) {
+			problem.Write(w, r, problem.New("unsupported-media-type", http.StatusUnsupportedMediaType, "Content-Type must be application/json"))
 			return
 		}
 
 		bodyBytes, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "Unable to read request body", http.StatusBadRequest)
+			problem.Write(w, r, problem.New("invalid-body", http.StatusBadRequest, "unable to read request body"))
 			return
 		}
 
@@ -43,7 +107,7 @@ func ValidateJSONMiddleware(next http.Handler) http.Handler {
 
 		var js json.RawMessage
 		if err := json.Unmarshal(bodyBytes, &js); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			problem.Write(w, r, problem.New("invalid-json", http.StatusBadRequest, "invalid JSON"))
 			return
 		}
 
@@ -51,11 +115,21 @@ func ValidateJSONMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// isJSONContentType accepts application/json and JSON based media types
+// such as application/merge-patch+json.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
 func RejectSQLInjection(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		bodyBytes, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "unable to read request", http.StatusBadRequest)
+			problem.Write(w, r, problem.New("invalid-body", http.StatusBadRequest, "unable to read request body"))
 			return
 		}
 
@@ -63,12 +137,12 @@ func RejectSQLInjection(next http.Handler) http.Handler {
 
 		var jsonBody any
 		if err := json.Unmarshal(bodyBytes, &jsonBody); err != nil {
-			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			problem.Write(w, r, problem.New("invalid-json", http.StatusBadRequest, "invalid JSON"))
 			return
 		}
 
 		if ContainsSQLInjection(jsonBody) {
-			http.Error(w, "request rejected: potential SQL injection detected", http.StatusBadRequest)
+			problem.Write(w, r, problem.New("rejected-input", http.StatusBadRequest, "request rejected: potential SQL injection detected"))
 			return
 		}
 
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"net/http"
 	"net/http/httptest"
+	"opskrifter-backend/internal/problem"
 	"testing"
+	"time"
 
 	"github.com/zeebo/assert"
 )
@@ -28,6 +30,24 @@ func TestValidateJSONMiddleware(t *testing.T) {
 			body:           `{"name": "bad json}"`,
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name:           "Merge Patch JSON",
+			contentType:    "application/merge-patch+json",
+			body:           `{"minutes": 20}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "JSON with charset",
+			contentType:    "application/json; charset=utf-8",
+			body:           `{"name": "example"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Plain text",
+			contentType:    "text/plain",
+			body:           `{"name": "example"}`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
 		{
 			name:           "Missing Content-Type",
 			contentType:    "",
@@ -104,3 +124,58 @@ func TestRejectSQLInjection(t *testing.T) {
 		})
 	}
 }
+
+func TestTimeout(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	handler := Timeout(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok = r.Context().Deadline()
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	assert.True(t, ok)
+	assert.True(t, time.Until(deadline) > 50*time.Second)
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = problem.RequestID(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(problem.RequestIDHeader, "abc")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, "abc", seen)
+	assert.Equal(t, "abc", resp.Header().Get(problem.RequestIDHeader))
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	assert.True(t, seen != "" && seen != "abc")
+	assert.Equal(t, seen, resp.Header().Get(problem.RequestIDHeader))
+}
+
+func TestAdminWrites(t *testing.T) {
+	handler := AdminWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(method string, key string) int {
+		req := httptest.NewRequest(method, "/", nil)
+		if key != "" {
+			req.Header.Set(AdminKeyHeader, key)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	t.Setenv("ADMIN_KEY", "")
+	assert.Equal(t, http.StatusOK, do("GET", ""))
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "")) // closed without an ADMIN_KEY
+
+	t.Setenv("ADMIN_KEY", "secret")
+	assert.Equal(t, http.StatusUnauthorized, do("PUT", "wrong"))
+	assert.Equal(t, http.StatusOK, do("PUT", "secret"))
+	assert.Equal(t, http.StatusOK, do("DELETE", "secret"))
+}
@@ -0,0 +1,69 @@
+// Package problem writes error responses as RFC 7807 problem details.
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+const ContentType = "application/problem+json"
+
+// TypeBase prefixes the type of every problem. The types are part of the API
+// and do not change once published.
+const TypeBase = "/problems/"
+
+const RequestIDHeader = "X-Request-Id"
+
+// Problem is the body of every error response.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// Errors lists the failing fields of a validation problem.
+	Errors any `json:"errors,omitempty"`
+}
+
+// New returns the problem of the given kind, e.g. "not-found". Its title is
+// the status text.
+func New(kind string, status int, detail string) Problem {
+	return Problem{
+		Type:   TypeBase + kind,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Write answers r with p, filling in the path and the id of the request.
+func Write(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	p.Instance = r.URL.Path
+	p.RequestID = RequestID(r.Context())
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Printf("failed to encode problem: %v", err)
+	}
+}
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the id the RequestID middleware gave the request, or ""
+// outside of it.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"opskrifter-backend/internal/types"
+	"opskrifter-backend/pkg/myDB"
 	"reflect"
 	"testing"
 )
@@ -17,10 +18,10 @@ func NewJSONPostRequest(data []byte) (*http.Request, *httptest.ResponseRecorder)
 	return req, rec
 }
 
-func AssertCountByType[T types.Identifiable](expected int, getFunc func(T) (int, error)) error {
+func AssertCountByType[T types.Identifiable](q myDB.Querier, expected int, getFunc func(myDB.Querier, T) (int, error)) error {
 	var obj T
 
-	count, err := getFunc(obj)
+	count, err := getFunc(q, obj)
 	if err != nil {
 		return fmt.Errorf("failed to get count: %w", err)
 	}
@@ -32,8 +33,8 @@ func AssertCountByType[T types.Identifiable](expected int, getFunc func(T) (int,
 	return nil
 }
 
-func AssertCountByTable(expected int, tableName string, getFunc func(string) (int, error)) error {
-	count, err := getFunc(tableName)
+func AssertCountByTable(q myDB.Querier, expected int, tableName string, getFunc func(myDB.Querier, string) (int, error)) error {
+	count, err := getFunc(q, tableName)
 	if err != nil {
 		return fmt.Errorf("failed to get count: %w", err)
 	}
@@ -37,6 +37,18 @@ func (g *TestDataGenerator[T]) Generate() T {
 			continue
 		}
 
+		if _, ok := t.Field(i).Tag.Lookup("version"); ok {
+			continue
+		}
+
+		// The server fills these in, whatever the client sends.
+		if _, ok := t.Field(i).Tag.Lookup("readonly"); ok {
+			continue
+		}
+		if _, ok := t.Field(i).Tag.Lookup("server"); ok {
+			continue
+		}
+
 		if t.Field(i).Name == "UserID" {
 			field.SetString(g.adminID)
 			continue
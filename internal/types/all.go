@@ -2,19 +2,21 @@ package types
 
 // Recipe
 type Recipe struct {
-	ID                string `json:"id" db:"id"`
-	Name              string `json:"name" db:"name"`
-	Minutes           int    `json:"minutes" db:"minutes"`
-	Description       string `json:"description" db:"description"`
-	Likes             int    `json:"likes" db:"likes"`
-	Comments          int    `json:"comments" db:"comments"`
-	Views             int    `json:"views" db:"views"`
-	Image             string `json:"image" db:"image"`
-	RecipeCuisine     string `json:"recipe_cuisine" db:"recipe_cuisine"`
-	UserID            string `json:"user_id" db:"user_id"`
-	CreatedAt         string `json:"created_at" db:"created_at"`
-	RecipeIngredients []RecipeIngredient
-	RecipeSteps       []RecipeStep
+	ID                string             `json:"id" db:"id"`
+	Name              string             `json:"name" db:"name" filter:"eq,like" validate:"required,min=1,max=200"`
+	Minutes           int                `json:"minutes" db:"minutes" filter:"eq,lt,lte,gt,gte" validate:"min=0,max=10080"`
+	Description       string             `json:"description" db:"description" validate:"max=10000"`
+	Likes             int                `json:"likes" db:"likes" filter:"eq,lt,lte,gt,gte" readonly:"true"`
+	Comments          int                `json:"comments" db:"comments" readonly:"true"`
+	Views             int                `json:"views" db:"views" readonly:"true"`
+	Image             string             `json:"image" db:"image" validate:"max=2048"`
+	RecipeCuisine     string             `json:"recipe_cuisine" db:"recipe_cuisine" filter:"eq,ne,in" validate:"max=100"`
+	UserID            string             `json:"user_id" db:"user_id" filter:"eq,ne,in" validate:"required" immutable:"true"`
+	CreatedAt         string             `json:"created_at" db:"created_at" filter:"lt,lte,gt,gte" server:"now"`
+	Version           int                `json:"version" db:"version" version:"true"`
+	DeletedAt         *string            `json:"deleted_at,omitempty" db:"deleted_at" soft_delete:"true"`
+	RecipeIngredients []RecipeIngredient `relation:"ingredients"`
+	RecipeSteps       []RecipeStep       `relation:"steps"`
 }
 
 func (Recipe) TableName() string { return "recipes" }
@@ -34,10 +36,11 @@ func (r Recipe) GetOneToMany() [][]OneToMany {
 // User
 type User struct {
 	ID        string `json:"id" db:"id"`
-	Name      string `json:"name" db:"name"`
-	Email     string `json:"email" db:"email"`
-	Status    string `json:"status" db:"status"`
-	CreatedAt string `json:"created_at" db:"created_at"`
+	Name      string `json:"name" db:"name" validate:"required,max=200"`
+	Email     string `json:"email" db:"email" validate:"required,max=320"`
+	Status    string `json:"status" db:"status" filter:"eq,ne,in"`
+	CreatedAt string `json:"created_at" db:"created_at" server:"now"`
+	Version   int    `json:"version" db:"version" version:"true"`
 }
 
 func (User) TableName() string { return "users" }
@@ -54,18 +57,42 @@ func (ulr UserLikedRecipe) GetChildID() string { return ulr.RecipeID }
 
 // Ingredient
 type Ingredient struct {
-	ID   string `json:"id" db:"id"`
-	Name string `json:"name" db:"name"`
+	ID      string `json:"id" db:"id" filter:"eq,in"`
+	Name    string `json:"name" db:"name" filter:"eq,in,like" validate:"required,min=1,max=100"`
+	NameKey string `json:"-" db:"name_key" fold:"name" natural_key:"true"`
 }
 
 func (Ingredient) TableName() string { return "ingredients" }
 func (i Ingredient) GetID() string   { return i.ID }
 
+// IngredientAlias is another name of an ingredient, e.g. "Piskefløde" for
+// "Fløde 38 %".
+type IngredientAlias struct {
+	ID           string `json:"id" db:"id" filter:"eq,in"`
+	Name         string `json:"name" db:"name" filter:"eq,like" validate:"required,min=1,max=100"`
+	NameKey      string `json:"-" db:"name_key" fold:"name" natural_key:"true"`
+	IngredientID string `json:"ingredient_id" db:"ingredient_id" filter:"eq,in" validate:"required"`
+}
+
+func (IngredientAlias) TableName() string { return "ingredient_aliases" }
+func (a IngredientAlias) GetID() string   { return a.ID }
+
+// IngredientParent places an ingredient below a more general one, e.g.
+// "Gedeost" below "Ost".
+type IngredientParent struct {
+	IngredientID string `json:"ingredient_id" db:"ingredient_id"`
+	ParentID     string `json:"parent_id" db:"parent_id"`
+}
+
+func (IngredientParent) TableName() string { return "ingredient_parents" }
+
 // RecipeIngredient
 type RecipeIngredient struct {
 	RecipeId     string `json:"recipe_id" db:"recipe_id" parent:"true"`
 	IngredientId string `json:"ingredient_id" db:"ingredient_id" child:"true"`
-	Amount       string `json:"amount" db:"amount"`
+	Amount       string `json:"amount" db:"amount" validate:"max=100"`
+	Name         string `json:"name,omitempty" db:"name" join:"ingredients.name" aliases:"ingredient_aliases.ingredient_id" validate:"max=100"`
+	Position     int    `json:"position" db:"position" position:"true" immutable:"true"`
 }
 
 func (RecipeIngredient) TableName() string     { return "ingredients_for_recipe" }
@@ -74,8 +101,9 @@ func (ri RecipeIngredient) GetChildID() string { return ri.IngredientId }
 // RecipeStep
 type RecipeStep struct {
 	ID       string `json:"id" db:"id"`
-	RecipeID string `json:"recipe_id" db:"recipe_id"`
-	Step     string `json:"step" db:"step"`
+	RecipeID string `json:"recipe_id" db:"recipe_id" parent:"true"`
+	Step     string `json:"step" db:"step" validate:"required,max=2000"`
+	Position int    `json:"position" db:"position" position:"true" immutable:"true"`
 }
 
 func (RecipeStep) TableName() string { return "recipe_steps" }
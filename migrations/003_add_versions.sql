@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE recipes ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE recipes DROP COLUMN version;
+ALTER TABLE users DROP COLUMN version;
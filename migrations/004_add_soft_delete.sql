@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE recipes ADD COLUMN deleted_at TEXT;
+CREATE INDEX IF NOT EXISTS idx_recipes_deleted_at ON recipes (deleted_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_recipes_deleted_at;
+ALTER TABLE recipes DROP COLUMN deleted_at;
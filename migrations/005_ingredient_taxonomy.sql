@@ -0,0 +1,47 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS ingredient_aliases (
+  id TEXT PRIMARY KEY NOT NULL,
+  name TEXT NOT NULL UNIQUE,
+  ingredient_id TEXT NOT NULL,
+  FOREIGN KEY (ingredient_id) REFERENCES ingredients(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_ingredient_aliases_ingredient_id ON ingredient_aliases (ingredient_id);
+
+CREATE TABLE IF NOT EXISTS ingredient_parents (
+  ingredient_id TEXT PRIMARY KEY NOT NULL,
+  parent_id TEXT NOT NULL,
+  FOREIGN KEY (ingredient_id) REFERENCES ingredients(id) ON DELETE CASCADE,
+  FOREIGN KEY (parent_id) REFERENCES ingredients(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_ingredient_parents_parent_id ON ingredient_parents (parent_id);
+
+INSERT INTO ingredients (id, name) VALUES ('654', 'Fløde');
+INSERT INTO ingredients (id, name) VALUES ('655', 'Juice');
+
+INSERT INTO ingredient_parents (ingredient_id, parent_id)
+SELECT id, '407' FROM ingredients WHERE name IN (
+  'Brie', 'Camembert', 'Cheddar', 'Danbo', 'Emmentaler', 'Feta', 'Flødeost', 'Gedeost', 'Gruyere', 'Havarti',
+  'Hytteost', 'Mozzarella', 'Myseost', 'Parmesan', 'Rejeost', 'Rygeost', 'Skinkeost 30+', 'Skummetmælksost',
+  'Skæreost', 'Smelteost'
+);
+INSERT INTO ingredient_parents (ingredient_id, parent_id)
+SELECT id, '654' FROM ingredients WHERE name IN ('Fløde 9 %', 'Fløde 13 %', 'Fløde 18 %', 'Fløde 38 %', 'Fløde 50 %');
+INSERT INTO ingredient_parents (ingredient_id, parent_id)
+SELECT id, '655' FROM ingredients WHERE name IN (
+  'Ananasjuice', 'Appelsinjuice', 'Blandet frugt- og grøntsagsjuice', 'Blandet frugtjuice', 'Blandet grøntsagsjuice',
+  'Grapefrugtjuice', 'Gulerodsjuice', 'Sveskejuice', 'Tomatjuice', 'Æblejuice'
+);
+
+INSERT INTO ingredient_aliases (id, name, ingredient_id) VALUES ('1', 'Kaffefløde', '126');
+INSERT INTO ingredient_aliases (id, name, ingredient_id) VALUES ('2', 'Madlavningsfløde', '123');
+INSERT INTO ingredient_aliases (id, name, ingredient_id) VALUES ('3', 'Piskefløde', '124');
+INSERT INTO ingredient_aliases (id, name, ingredient_id) VALUES ('4', 'Appelsinsaft', '13');
+INSERT INTO ingredient_aliases (id, name, ingredient_id) VALUES ('5', 'Æblesaft', '647');
+INSERT INTO ingredient_aliases (id, name, ingredient_id) VALUES ('6', 'Cottage cheese', '231');
+INSERT INTO ingredient_aliases (id, name, ingredient_id) VALUES ('7', 'Parmigiano', '414');
+INSERT INTO ingredient_aliases (id, name, ingredient_id) VALUES ('8', 'Cheese', '407');
+
+-- +goose Down
+DROP TABLE IF EXISTS ingredient_parents;
+DROP TABLE IF EXISTS ingredient_aliases;
+DELETE FROM ingredients WHERE id IN ('654', '655');
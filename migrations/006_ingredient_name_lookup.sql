@@ -0,0 +1,49 @@
+-- +goose Up
+-- name_key holds the name folded the way the server's normalizeName folds it,
+-- so names can be looked up ignoring case and accents through an index. The
+-- server writes it on every insert and update; the backfill spells out the
+-- folding for the letters the seeded names use.
+ALTER TABLE ingredients ADD COLUMN name_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE ingredient_aliases ADD COLUMN name_key TEXT NOT NULL DEFAULT '';
+
+UPDATE ingredients SET name_key = LOWER(
+  REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(TRIM(name),
+    'Æ', 'ae'), 'æ', 'ae'), 'Ø', 'oe'), 'ø', 'oe'), 'Å', 'aa'), 'å', 'aa'), 'é', 'e'), 'ü', 'u'), 'û', 'u')
+);
+UPDATE ingredient_aliases SET name_key = LOWER(
+  REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(TRIM(name),
+    'Æ', 'ae'), 'æ', 'ae'), 'Ø', 'oe'), 'ø', 'oe'), 'Å', 'aa'), 'å', 'aa'), 'é', 'e'), 'ü', 'u'), 'û', 'u')
+);
+
+-- Ingredients whose names only differ in case, like "Pop Corn" and
+-- "Pop corn", become the one with the lowest id. Rows of a recipe already
+-- using that one are removed with the duplicate.
+UPDATE OR IGNORE ingredients_for_recipe SET ingredient_id = (
+  SELECT MIN(k.id) FROM ingredients k JOIN ingredients i ON i.name_key = k.name_key
+  WHERE i.id = ingredients_for_recipe.ingredient_id
+);
+UPDATE ingredient_aliases SET ingredient_id = (
+  SELECT MIN(k.id) FROM ingredients k JOIN ingredients i ON i.name_key = k.name_key
+  WHERE i.id = ingredient_aliases.ingredient_id
+);
+UPDATE OR IGNORE ingredient_parents SET parent_id = (
+  SELECT MIN(k.id) FROM ingredients k JOIN ingredients i ON i.name_key = k.name_key
+  WHERE i.id = ingredient_parents.parent_id
+);
+UPDATE OR IGNORE ingredient_parents SET ingredient_id = (
+  SELECT MIN(k.id) FROM ingredients k JOIN ingredients i ON i.name_key = k.name_key
+  WHERE i.id = ingredient_parents.ingredient_id
+);
+DELETE FROM ingredients WHERE id <> (
+  SELECT MIN(k.id) FROM ingredients k WHERE k.name_key = ingredients.name_key
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ingredients_name_key ON ingredients (name_key);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ingredient_aliases_name_key ON ingredient_aliases (name_key);
+
+-- +goose Down
+-- Merged ingredients stay merged.
+DROP INDEX IF EXISTS idx_ingredient_aliases_name_key;
+DROP INDEX IF EXISTS idx_ingredients_name_key;
+ALTER TABLE ingredient_aliases DROP COLUMN name_key;
+ALTER TABLE ingredients DROP COLUMN name_key;
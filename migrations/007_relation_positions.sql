@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE recipe_steps ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+UPDATE recipe_steps SET position = (
+  SELECT COUNT(*) FROM recipe_steps AS earlier
+  WHERE earlier.recipe_id = recipe_steps.recipe_id AND earlier.rowid < recipe_steps.rowid
+);
+
+ALTER TABLE ingredients_for_recipe ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+UPDATE ingredients_for_recipe SET position = (
+  SELECT COUNT(*) FROM ingredients_for_recipe AS earlier
+  WHERE earlier.recipe_id = ingredients_for_recipe.recipe_id AND earlier.rowid < ingredients_for_recipe.rowid
+);
+
+-- +goose Down
+ALTER TABLE ingredients_for_recipe DROP COLUMN position;
+ALTER TABLE recipe_steps DROP COLUMN position;
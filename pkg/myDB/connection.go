@@ -5,43 +5,69 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pressly/goose"
 )
 
-var DB *sqlx.DB
+// Store is the database the API reads from and writes to.
+type Store interface {
+	Querier
+	beginner
+	Close() error
+}
 
-func Init(inMemory bool) error {
-	var err error
-	dsn := "file:app.db?mode=rwc&_fk=on&_journal_mode=WAL"
-	if inMemory {
-		dsn = "file:sharedmemdb?mode=memory&cache=shared&_fk=on"
-	}
+// SQLiteStore is a Store backed by SQLite, either the app.db file or a
+// private in-memory database.
+type SQLiteStore struct {
+	*sqlx.DB
+}
+
+var gooseDialect sync.Once
+
+// NewSQLiteStore opens app.db in the working directory and migrates it.
+func NewSQLiteStore() (*SQLiteStore, error) {
+	return open("file:app.db?mode=rwc&_fk=on&_journal_mode=WAL", false)
+}
 
+// NewMemoryStore returns a migrated in-memory database that is not shared
+// with any other store, which makes it a cheap fake for tests.
+func NewMemoryStore() (*SQLiteStore, error) {
+	return open(fmt.Sprintf("file:%s?mode=memory&cache=shared&_fk=on", uuid.NewString()), true)
+}
+
+func open(dsn string, inMemory bool) (*SQLiteStore, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	DB, err = sqlx.Open("sqlite3", dsn)
+	db, err := sqlx.Open("sqlite3", dsn)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err = DB.Ping(); err != nil {
-		return fmt.Errorf("failed to ping DB: %w", err)
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping DB: %w", err)
 	}
 
-	if err := goose.SetDialect("sqlite3"); err != nil {
-		return err
+	gooseDialect.Do(func() {
+		err = goose.SetDialect("sqlite3")
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
 
 	packageRoot, err := findProjectRoot()
 
 	if err != nil {
-		return err
+		db.Close()
+		return nil, err
 	}
 
 	migrationsDir := filepath.Join(packageRoot, "migrations")
@@ -49,15 +75,21 @@ func Init(inMemory bool) error {
 		log.Fatalf("migrations directory does not exist: %s", migrationsDir)
 	}
 
-	if err := goose.Up(DB.DB, migrationsDir); err != nil {
+	if err := goose.Up(db.DB, migrationsDir); err != nil {
 		log.Fatalf("failed to run schema migrations: %v", err)
 	}
 
-	err = checkDBSettings(inMemory, cwd)
+	err = checkDBSettings(db, inMemory, cwd)
 
 	if err != nil {
-		return err
+		db.Close()
+		return nil, err
+	}
+
+	if err := checkModels(db, inMemory || StrictSchema); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	return nil
+	return &SQLiteStore{DB: db}, nil
 }
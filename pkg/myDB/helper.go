@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -27,9 +28,9 @@ func findProjectRoot() (string, error) {
 	}
 }
 
-func checkDBSettings(inMemory bool, cwd string) error {
+func checkDBSettings(db *sqlx.DB, inMemory bool, cwd string) error {
 	var fkEnabled int
-	err := DB.QueryRow("PRAGMA foreign_keys;").Scan(&fkEnabled)
+	err := db.QueryRow("PRAGMA foreign_keys;").Scan(&fkEnabled)
 	if err != nil {
 		return fmt.Errorf("failed to query foreign_keys: %w", err)
 	}
@@ -39,7 +40,7 @@ func checkDBSettings(inMemory bool, cwd string) error {
 	}
 
 	var journalMode string
-	err = DB.QueryRow("PRAGMA journal_mode;").Scan(&journalMode)
+	err = db.QueryRow("PRAGMA journal_mode;").Scan(&journalMode)
 	if err != nil {
 		return fmt.Errorf("failed to query journal_mode: %w", err)
 	}
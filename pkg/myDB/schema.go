@@ -0,0 +1,181 @@
+package myDB
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Model is a type stored in a table, one column per db tagged field.
+type Model interface {
+	TableName() string
+}
+
+// StrictSchema makes a store refuse to open when the registered models do
+// not match the schema. Otherwise the differences are only logged. In-memory
+// stores are always strict, so tests catch a typo in a db tag.
+var StrictSchema = false
+
+var (
+	modelsMu sync.Mutex
+	models   []Model
+)
+
+// RegisterModels adds models to the types checked against the schema when a
+// store is opened.
+func RegisterModels(m ...Model) {
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	models = append(models, m...)
+}
+
+// Drift is one difference between a model and its table.
+type Drift struct {
+	Table  string
+	Column string
+	Reason string
+}
+
+func (d Drift) String() string {
+	if d.Column == "" {
+		return fmt.Sprintf("%s: %s", d.Table, d.Reason)
+	}
+	return fmt.Sprintf("%s.%s: %s", d.Table, d.Column, d.Reason)
+}
+
+// SchemaDriftError lists every difference CheckSchema found.
+type SchemaDriftError struct {
+	Drifts []Drift
+}
+
+func (e *SchemaDriftError) Error() string {
+	lines := make([]string, len(e.Drifts))
+	for i, d := range e.Drifts {
+		lines[i] = d.String()
+	}
+	return "schema does not match the models: " + strings.Join(lines, "; ")
+}
+
+type tableColumn struct {
+	Name string `db:"name"`
+	Type string `db:"type"`
+}
+
+// CheckSchema compares the db tags of models with the columns SQLite reports
+// for their tables: every tag needs a column of a matching type, and every
+// column a tag, since SELECT * cannot scan a column without one.
+func CheckSchema(q Querier, models ...Model) error {
+	var drifts []Drift
+	for _, model := range models {
+		table := model.TableName()
+
+		var columns []tableColumn
+		if err := q.Select(&columns, "SELECT name, type FROM pragma_table_info(?)", table); err != nil {
+			return fmt.Errorf("failed to read columns of %s: %w", table, err)
+		}
+		if len(columns) == 0 {
+			drifts = append(drifts, Drift{Table: table, Reason: "table does not exist"})
+			continue
+		}
+
+		fields := modelColumns(reflect.TypeOf(model))
+		for _, col := range columns {
+			kind, ok := fields[col.Name]
+			if !ok {
+				drifts = append(drifts, Drift{Table: table, Column: col.Name, Reason: "column has no field"})
+				continue
+			}
+			if want := affinity(col.Type); !slices.Contains(affinitiesOf(kind), want) {
+				drifts = append(drifts, Drift{Table: table, Column: col.Name,
+					Reason: fmt.Sprintf("column is %s but the field is %s", col.Type, kind)})
+			}
+			delete(fields, col.Name)
+		}
+
+		missing := make([]string, 0, len(fields))
+		for name := range fields {
+			missing = append(missing, name)
+		}
+		slices.Sort(missing)
+		for _, name := range missing {
+			drifts = append(drifts, Drift{Table: table, Column: name, Reason: "field has no column"})
+		}
+	}
+
+	if len(drifts) > 0 {
+		return &SchemaDriftError{Drifts: drifts}
+	}
+	return nil
+}
+
+// modelColumns returns the kind of every stored field of t by column. Fields
+// with a join tag are read from another table and are skipped.
+func modelColumns(t reflect.Type) map[string]reflect.Kind {
+	columns := map[string]reflect.Kind{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		col := field.Tag.Get("db")
+		if col == "" || field.Tag.Get("join") != "" {
+			continue
+		}
+
+		ft := field.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		columns[col] = ft.Kind()
+	}
+	return columns
+}
+
+// affinity is the type affinity SQLite gives a declared column type.
+func affinity(declared string) string {
+	declared = strings.ToUpper(declared)
+	switch {
+	case strings.Contains(declared, "INT"):
+		return "INTEGER"
+	case strings.Contains(declared, "CHAR"), strings.Contains(declared, "CLOB"), strings.Contains(declared, "TEXT"):
+		return "TEXT"
+	case declared == "", strings.Contains(declared, "BLOB"):
+		return "BLOB"
+	case strings.Contains(declared, "REAL"), strings.Contains(declared, "FLOA"), strings.Contains(declared, "DOUB"):
+		return "REAL"
+	}
+	return "NUMERIC"
+}
+
+// affinitiesOf lists the affinities a field of kind can be scanned from.
+func affinitiesOf(kind reflect.Kind) []string {
+	switch kind {
+	case reflect.String:
+		return []string{"TEXT"}
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return []string{"INTEGER", "NUMERIC"}
+	case reflect.Float32, reflect.Float64:
+		return []string{"REAL", "NUMERIC", "INTEGER"}
+	case reflect.Slice:
+		return []string{"BLOB", "TEXT"}
+	}
+	return nil
+}
+
+// checkModels runs CheckSchema on the registered models. A drift fails when
+// strict and is logged otherwise.
+func checkModels(db *sqlx.DB, strict bool) error {
+	modelsMu.Lock()
+	registered := slices.Clone(models)
+	modelsMu.Unlock()
+
+	err := CheckSchema(db, registered...)
+	if _, drifted := err.(*SchemaDriftError); drifted && !strict {
+		log.Printf("warning: %v", err)
+		return nil
+	}
+	return err
+}
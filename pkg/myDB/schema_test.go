@@ -0,0 +1,49 @@
+package myDB
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stepModel struct {
+	ID       string `db:"id"`
+	RecipeID string `db:"recipe_id"`
+	Step     string `db:"step"`
+	Position int    `db:"position"`
+	Recipe   string `db:"recipe_name" join:"recipes.name"`
+}
+
+func (stepModel) TableName() string { return "recipe_steps" }
+
+type driftedStep struct {
+	ID       string `db:"id"`
+	Step     int    `db:"step"`
+	Position int    `db:"position"`
+	Title    string `db:"titel"`
+}
+
+func (driftedStep) TableName() string { return "recipe_steps" }
+
+type noTable struct{}
+
+func (noTable) TableName() string { return "nowhere" }
+
+func TestCheckSchema(t *testing.T) {
+	store, err := NewMemoryStore()
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	require.NoError(t, CheckSchema(store, stepModel{}))
+
+	err = CheckSchema(store, driftedStep{}, noTable{})
+	var drift *SchemaDriftError
+	require.ErrorAs(t, err, &drift)
+	assert.Equal(t, []Drift{
+		{Table: "recipe_steps", Column: "recipe_id", Reason: "column has no field"},
+		{Table: "recipe_steps", Column: "step", Reason: "column is TEXT but the field is int"},
+		{Table: "recipe_steps", Column: "titel", Reason: "field has no column"},
+		{Table: "nowhere", Reason: "table does not exist"},
+	}, drift.Drifts)
+}
@@ -0,0 +1,71 @@
+package myDB
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Querier is the subset of sqlx shared by *sqlx.DB and *sqlx.Tx, so the
+// same CRUD code can run on its own or as part of a larger transaction.
+type Querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Get(dest any, query string, args ...any) error
+	Select(dest any, query string, args ...any) error
+	QueryRow(query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type beginner interface {
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error)
+}
+
+// WithTx runs fn as one unit of work. If q is already a transaction fn joins
+// it, otherwise a new transaction is started and committed when fn returns
+// nil, or rolled back when fn returns an error or panics.
+func WithTx(q Querier, fn func(tx Querier) error) error {
+	return WithTxContext(context.Background(), q, fn)
+}
+
+// WithTxContext is WithTx with a transaction that is rolled back when ctx is
+// done before it commits.
+func WithTxContext(ctx context.Context, q Querier, fn func(tx Querier) error) error {
+	if tx, ok := q.(*sqlx.Tx); ok {
+		return fn(tx)
+	}
+
+	db, ok := q.(beginner)
+	if !ok {
+		return fmt.Errorf("querier %T cannot begin a transaction", q)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}